@@ -4,19 +4,27 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"registry-secret-manager/pkg/cleanup"
+	"registry-secret-manager/pkg/pod"
+	"registry-secret-manager/pkg/pullfailure"
 	"registry-secret-manager/pkg/registry"
 	"registry-secret-manager/pkg/secret"
 	"registry-secret-manager/pkg/serviceaccount"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -35,7 +43,7 @@ type RegistrySecretManager struct {
 }
 
 // ClosureRegistry holds a closure that returns a Registry instance.
-type ClosureRegistry func() registry.Registry
+type ClosureRegistry func() (registry.Registry, error)
 
 // NewRegistrySecretManager returns a pointer to RegistrySecretManager.
 func NewRegistrySecretManager() *RegistrySecretManager {
@@ -94,12 +102,15 @@ func readConfig() (Config, error) {
 		return config, fmt.Errorf("failed to get the home directory: %w", err)
 	}
 
+	viper.AddConfigPath("/etc/registry-secret-manager")
 	viper.AddConfigPath(".")
 	viper.AddConfigPath(filepath.Dir(executable))
 	viper.AddConfigPath(home)
 	viper.SetConfigName("config")
 	viper.SetConfigType("yml")
 
+	viper.SetDefault("docker-hub.rate-limit-warning", 20)
+
 	viper.SetEnvPrefix("REGISTRY_SECRET_MANAGER")
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
@@ -121,11 +132,72 @@ func bindFlags(flag *pflag.Flag) {
 
 func getAvailableRegistries() map[string]ClosureRegistry {
 	return map[string]ClosureRegistry{
-		registry.DockerHubName: func() registry.Registry {
-			return registry.NewDockerHub()
+		registry.DockerHubName: func() (registry.Registry, error) {
+			var dockerHubConfig registry.DockerHubConfig
+			if err := viper.UnmarshalKey("docker-hub", &dockerHubConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the Docker Hub config: %w", err)
+			}
+
+			return registry.NewDockerHub(dockerHubConfig), nil
+		},
+		registry.EcrName: func() (registry.Registry, error) {
+			var accounts []registry.EcrAccount
+			if err := viper.UnmarshalKey("ecr.accounts", &accounts); err != nil {
+				return nil, fmt.Errorf("failed to parse the ECR accounts: %w", err)
+			}
+
+			return registry.NewECR(accounts, registry.NewEcrClient), nil
+		},
+		registry.EcrPublicName: func() (registry.Registry, error) {
+			return registry.NewEcrPublic(registry.NewEcrPublicClient), nil
+		},
+		registry.GhcrName: func() (registry.Registry, error) {
+			var ghcrConfig registry.GhcrConfig
+			if err := viper.UnmarshalKey("ghcr", &ghcrConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the GHCR config: %w", err)
+			}
+
+			return registry.NewGhcr(ghcrConfig)
+		},
+		registry.GitLabName: func() (registry.Registry, error) {
+			var gitLabConfig registry.GitLabConfig
+			if err := viper.UnmarshalKey("gitlab", &gitLabConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the GitLab config: %w", err)
+			}
+
+			return registry.NewGitLab(gitLabConfig)
+		},
+		registry.VaultName: func() (registry.Registry, error) {
+			var vaultConfig registry.VaultConfig
+			if err := viper.UnmarshalKey("vault", &vaultConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the Vault config: %w", err)
+			}
+
+			return registry.NewVault(vaultConfig)
+		},
+		registry.KubernetesSecretName: func() (registry.Registry, error) {
+			var kubernetesSecretConfig registry.KubernetesSecretConfig
+			if err := viper.UnmarshalKey("kubernetes-secret", &kubernetesSecretConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the Kubernetes Secret config: %w", err)
+			}
+
+			return registry.NewKubernetesSecret(kubernetesSecretConfig)
 		},
-		registry.EcrName: func() registry.Registry {
-			return registry.NewECR()
+		registry.FileName: func() (registry.Registry, error) {
+			var fileConfig registry.FileConfig
+			if err := viper.UnmarshalKey("file", &fileConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the file config: %w", err)
+			}
+
+			return registry.NewFile(fileConfig)
+		},
+		registry.TokenServiceName: func() (registry.Registry, error) {
+			var tokenServiceConfig registry.TokenServiceConfig
+			if err := viper.UnmarshalKey("token-service", &tokenServiceConfig); err != nil {
+				return nil, fmt.Errorf("failed to parse the token service config: %w", err)
+			}
+
+			return registry.NewTokenService(tokenServiceConfig)
 		},
 	}
 }
@@ -139,8 +211,24 @@ func getCommand() *cobra.Command {
 	}
 
 	pflag.String("cert-dir", "", "Directory that holds the tls.crt and tls.key files")
+	pflag.Bool("cleanup", false, "Keep on removing the Secrets and the references added to the ServiceAccounts, instead of managing them")
+	pflag.Duration("credentials-cache-ttl", time.Hour, "Duration for which the registry credentials are reused before a new login")
+	pflag.String("email", "", "Email written into the docker config, omitted when empty")
+	pflag.Bool("immutable-secrets", false, "Write every refresh into a new immutable Secret with a versioned name, and repoint the ServiceAccounts to it")
+	pflag.Duration("immutable-secrets-grace-period", time.Hour, "Duration for which a superseded version of an immutable Secret is kept, before it is deleted once no ServiceAccount references it")
+	pflag.Bool("image-aware-injection", false, "Only inject the Secret into Pods pulling images from the registry endpoints, instead of every ServiceAccount")
 	pflag.String("log-level", "warning", "Log verbosity level")
+	pflag.Bool("merge-foreign-entries", false, "Keep the entries added by others to the Secrets on refresh")
+	pflag.String("namespace", cleanup.ManagerName, "Namespace of the manager, which is stopped by the cleanup command")
+	pflag.Bool("pod-webhook", false, "Inject the Secret directly into Pods pulling images from the registry endpoints")
+	pflag.StringSlice("pod-webhook-endpoint", nil, "Define which registry endpoints require the Secret on Pods, prefixed with their registry when one Secret is created per registry, eg: ecr=123456789012.dkr.ecr.eu-west-1.amazonaws.com")
+	pflag.Bool("pull-failure-refresh", false, "Refresh the Secret of a namespace when its Pods fail to pull images because of the credentials")
+	pflag.Duration("pull-failure-refresh-interval", 10*time.Minute, "Minimum duration between two refreshes of a Secret after failed pulls")
+	pflag.String("refresh-token", "", "Bearer token authenticating the on-demand refresh of the Secrets, the endpoint is disabled when empty")
 	pflag.StringSlice("registry", nil, fmt.Sprintf("Define which registries should be enabled [%s]", strings.Join(keys, ",")))
+	pflag.Bool("secret-per-registry", false, "Create one Secret per registry, named after the registry, instead of a single Secret")
+	pflag.String("secret-type", string(corev1.SecretTypeDockerConfigJson), fmt.Sprintf("Type of the Secrets [%s,%s]", corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg))
+	pflag.Bool("verify-credentials", false, "Verify the credentials against the registry, and the canary images of its registry, before publishing them")
 	pflag.Parse()
 
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
@@ -149,10 +237,39 @@ func getCommand() *cobra.Command {
 
 	pflag.VisitAll(bindFlags)
 
-	return &cobra.Command{
+	command := &cobra.Command{
 		Use:   "registry-secret-manager",
 		Short: "Manages the creation and distribution of credentials for container registries",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if viper.GetBool("cleanup") {
+				return runCleanupManager()
+			}
+
+			secretOptions := secret.Options{
+				Type:                corev1.SecretType(viper.GetString("secret-type")),
+				MergeForeignEntries: viper.GetBool("merge-foreign-entries"),
+				PerRegistry:         viper.GetBool("secret-per-registry"),
+				Immutable:           viper.GetBool("immutable-secrets"),
+				GracePeriod:         viper.GetDuration("immutable-secrets-grace-period"),
+			}
+
+			configHash, err := secret.HashConfig(getRenderedConfig())
+			if err != nil {
+				return fmt.Errorf("failed to hash the configuration: %w", err)
+			}
+
+			secretOptions.ConfigHash = configHash
+
+			switch secretOptions.Type {
+			case corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg:
+			default:
+				return fmt.Errorf("unsupported secret type %s", secretOptions.Type)
+			}
+
+			if secretOptions.Immutable && secretOptions.GracePeriod <= 0 {
+				return fmt.Errorf("the immutable secrets grace period must be positive, got %s", secretOptions.GracePeriod)
+			}
+
 			registries, err := parseEnabledRegistries(availableRegistries)
 			if err != nil {
 				return fmt.Errorf("failed to add registries: %w", err)
@@ -163,7 +280,7 @@ func getCommand() *cobra.Command {
 				return fmt.Errorf("failed to get the config: %w", err)
 			}
 
-			mgr, err := setupManager(cfg, registries)
+			mgr, err := setupManager(cfg, registries, secretOptions)
 			if err != nil {
 				return fmt.Errorf("failed to setup the manager: %w", err)
 			}
@@ -179,6 +296,120 @@ func getCommand() *cobra.Command {
 			return nil
 		},
 	}
+
+	command.AddCommand(&cobra.Command{
+		Use:   "cleanup",
+		Short: "Stops the manager, and removes the Secrets and the references added to the ServiceAccounts, eg: before uninstalling",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.GetConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get the config: %w", err)
+			}
+
+			c, err := client.New(cfg, client.Options{})
+			if err != nil {
+				return fmt.Errorf("failed to create the client: %w", err)
+			}
+
+			ctx := signals.SetupSignalHandler()
+
+			err = cleanup.StopManager(ctx, c, viper.GetString("namespace"))
+			if err != nil {
+				return fmt.Errorf("failed to stop the manager: %w", err)
+			}
+
+			err = cleanup.Run(ctx, c)
+			if err != nil {
+				return fmt.Errorf("failed to clean up: %w", err)
+			}
+
+			log.Infof("Successfully cleaned up")
+
+			return nil
+		},
+	})
+
+	return command
+}
+
+// Runs the manager in cleanup mode, no registry is needed.
+func runCleanupManager() error {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get the config: %w", err)
+	}
+
+	mgr, err := newManager(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup the manager: %w", err)
+	}
+
+	err = cleanup.NewController(mgr)
+	if err != nil {
+		return fmt.Errorf("failed to add the cleanup controller: %w", err)
+	}
+
+	log.Infof("Starting controller manager in cleanup mode")
+
+	err = mgr.Start(signals.SetupSignalHandler())
+	if err != nil {
+		return fmt.Errorf("unable to start manager: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the configuration the Secrets are rendered with, including the credentials from the environment. The ones
+// rendered with another configuration are rendered again right away on startup.
+func getRenderedConfig() map[string]interface{} {
+	environment := map[string]string{}
+
+	for _, key := range registry.CredentialsEnvironment {
+		if value, ok := os.LookupEnv(key); ok {
+			environment[key] = value
+		}
+	}
+
+	renderedConfig := map[string]interface{}{
+		"environment": environment,
+	}
+
+	for _, key := range []string{"email", "emails", "immutable-secrets", "merge-foreign-entries", "registry", "secret-per-registry", "secret-type"} {
+		renderedConfig[key] = viper.Get(key)
+	}
+
+	for _, registryName := range viper.GetStringSlice("registry") {
+		renderedConfig[registryName] = viper.Get(registryName)
+	}
+
+	return renderedConfig
+}
+
+// Returns the Secret holding the credentials of each pod webhook endpoint. With one Secret per registry, the endpoints
+// are prefixed with the name of their registry, eg: "ecr=123456789012.dkr.ecr.eu-west-1.amazonaws.com".
+func parsePodWebhookEndpoints(endpoints []string, registries []registry.Registry, options secret.Options) (map[string]string, error) {
+	secrets := secret.Group(registries, options)
+	endpointSecrets := map[string]string{}
+
+	for _, endpoint := range endpoints {
+		registryName, registryEndpoint, found := strings.Cut(endpoint, "=")
+		if !found {
+			if options.PerRegistry {
+				return nil, fmt.Errorf("endpoint %s must be prefixed with the name of its registry, eg: ecr=%s", endpoint, endpoint)
+			}
+
+			registryName, registryEndpoint = "", endpoint
+		}
+
+		name := secret.SecretName(registryName, options)
+		if _, ok := secrets[name]; !ok {
+			return nil, fmt.Errorf("registry %s of endpoint %s isn't enabled", registryName, registryEndpoint)
+		}
+
+		endpointSecrets[registryEndpoint] = name
+	}
+
+	return endpointSecrets, nil
 }
 
 func parseEnabledRegistries(availableRegistries map[string]ClosureRegistry) ([]registry.Registry, error) {
@@ -191,7 +422,37 @@ func parseEnabledRegistries(availableRegistries map[string]ClosureRegistry) ([]r
 			return nil, fmt.Errorf("unknown registry %s", registryName)
 		}
 
-		registries = append(registries, f())
+		r, err := f()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure registry %s: %w", registryName, err)
+		}
+
+		// Verified before being cached, so that credentials failing the verification are retried on the next login
+		if viper.GetBool("verify-credentials") {
+			r, err = registry.NewVerify(r, viper.GetStringSlice("canaries."+registryName))
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure registry %s: %w", registryName, err)
+			}
+		}
+
+		// Source Secrets and files are cheap to read, and must not be cached so that their changes propagate immediately
+		switch registry.Unwrap(r).(type) {
+		case *registry.KubernetesSecret, *registry.File:
+		default:
+			r = registry.NewCache(r, viper.GetDuration("credentials-cache-ttl"))
+		}
+
+		// The email is omitted unless configured for the registry or globally
+		email := viper.GetString("emails." + registryName)
+		if email == "" {
+			email = viper.GetString("email")
+		}
+
+		if email != "" {
+			r = registry.NewEmail(r, email)
+		}
+
+		registries = append(registries, registry.NewNamed(r, registryName))
 	}
 
 	if len(registries) < 1 {
@@ -201,7 +462,7 @@ func parseEnabledRegistries(availableRegistries map[string]ClosureRegistry) ([]r
 	return registries, nil
 }
 
-func setupManager(cfg *rest.Config, registries []registry.Registry) (manager.Manager, error) {
+func newManager(cfg *rest.Config) (manager.Manager, error) {
 	mgr, err := manager.New(cfg, manager.Options{
 		Host:    "",
 		Port:    ManagerPort,
@@ -214,6 +475,18 @@ func setupManager(cfg *rest.Config, registries []registry.Registry) (manager.Man
 		LeaderElectionID:           "registry-secret-manager",
 		LeaderElectionNamespace:    "registry-secret-manager",
 		LeaderElectionResourceLock: resourcelock.LeasesResourceLock,
+
+		// Only the warnings about Pods are cached out of all the Events, the failed pulls being some of them
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Event{}: {
+					Field: fields.SelectorFromSet(fields.Set{
+						"involvedObject.kind": "Pod",
+						"type":                corev1.EventTypeWarning,
+					}),
+				},
+			},
+		}),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("unable to set up overall controller manager: %w", err)
@@ -230,16 +503,88 @@ func setupManager(cfg *rest.Config, registries []registry.Registry) (manager.Man
 		return nil, fmt.Errorf("failed to add ping readyz check: %w", err)
 	}
 
-	// Setup a new controller to reconcile ServiceAccounts and Secrets
-	err = serviceaccount.NewController(mgr, registries)
+	return mgr, nil
+}
+
+func setupManager(cfg *rest.Config, registries []registry.Registry, secretOptions secret.Options) (manager.Manager, error) {
+	mgr, err := newManager(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add the serviceaccount controller: %w", err)
+		return nil, err
+	}
+
+	// Provide the client of the manager to the registries reading Kubernetes objects, and run their watchers
+	for _, r := range registries {
+		err = mgr.SetFields(registry.Unwrap(r))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inject the registry dependencies: %w", err)
+		}
+
+		if runnable, ok := registry.Unwrap(r).(manager.Runnable); ok {
+			err = mgr.Add(runnable)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add the registry watcher: %w", err)
+			}
+		}
 	}
 
-	err = secret.NewController(mgr, registries)
+	// Setup a queue to create the Secrets requested by the webhooks in the background
+	queue := secret.NewQueue(mgr.GetClient(), registries, secretOptions)
+
+	err = mgr.Add(queue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add the secret queue: %w", err)
+	}
+
+	// Setup a new controller to reconcile ServiceAccounts and Secrets, unless the Pods get the Secret based on their images
+	imageAware := viper.GetBool("image-aware-injection")
+	if imageAware && !viper.GetBool("pod-webhook") {
+		return nil, fmt.Errorf("the pod webhook must be enabled for image aware injection")
+	}
+
+	// The Pods reference a version of the Secret for good, only the ServiceAccounts can be repointed to the next ones
+	if secretOptions.Immutable && viper.GetBool("pod-webhook") {
+		return nil, fmt.Errorf("the pod webhook can't inject immutable secrets")
+	}
+
+	if !imageAware {
+		err = serviceaccount.NewController(mgr, registries, queue, secretOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add the serviceaccount controller: %w", err)
+		}
+	}
+
+	err = secret.NewController(mgr, registries, secretOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add the secret controller: %w", err)
 	}
 
+	// Optionally refresh the Secrets when Pods fail to pull their images because of the credentials
+	if viper.GetBool("pull-failure-refresh") {
+		err = pullfailure.NewController(mgr, viper.GetDuration("pull-failure-refresh-interval"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to add the pull failure controller: %w", err)
+		}
+	}
+
+	// Optionally serve the on-demand refresh of the Secrets, authenticated by a bearer token
+	if token := viper.GetString("refresh-token"); token != "" {
+		mgr.GetWebhookServer().Register("/refresh", secret.NewRefreshHandler(mgr.GetClient(), token))
+	}
+
+	// Optionally inject the Secret into Pods whose ServiceAccounts are managed by someone else
+	if viper.GetBool("pod-webhook") {
+		endpoints := viper.GetStringSlice("pod-webhook-endpoint")
+		if len(endpoints) < 1 {
+			return nil, fmt.Errorf("at least one pod webhook endpoint must be defined")
+		}
+
+		secrets, err := parsePodWebhookEndpoints(endpoints, registries, secretOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the pod webhook endpoints: %w", err)
+		}
+
+		pod.NewWebhook(mgr, queue, queue, secrets)
+	}
+
 	return mgr, nil
 }
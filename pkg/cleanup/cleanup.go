@@ -0,0 +1,206 @@
+package cleanup
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"registry-secret-manager/pkg/secret"
+	"registry-secret-manager/pkg/serviceaccount"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ManagerName is the name of both the Deployment and the MutatingWebhookConfiguration of the manager.
+const ManagerName = "registry-secret-manager"
+
+// StopTimeout is how long we wait for the manager to be gone.
+const StopTimeout = 2 * time.Minute
+
+// StopManager deletes the webhook configuration and the Deployment of the manager, and waits for its Pods to be gone.
+// Otherwise the manager adds the Secrets and the references back while they are cleaned up.
+func StopManager(ctx context.Context, c client.Client, namespace string) error {
+	webhookConfiguration := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ManagerName,
+		},
+	}
+
+	err := c.Delete(ctx, webhookConfiguration)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete the MutatingWebhookConfiguration [%s]: %w", ManagerName, err)
+	}
+
+	deploymentName := types.NamespacedName{Namespace: namespace, Name: ManagerName}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: deploymentName.Namespace,
+			Name:      deploymentName.Name,
+		},
+	}
+
+	// The Deployment is only gone once its Pods are, as they are deleted in the foreground
+	err = c.Delete(ctx, deployment, client.PropagationPolicy(metav1.DeletePropagationForeground))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not delete the Deployment [%s]: %w", deploymentName, err)
+	}
+
+	log.Infof("Waiting for the Deployment [%s] to be gone", deploymentName)
+
+	ctx, cancel := context.WithTimeout(ctx, StopTimeout)
+	defer cancel()
+
+	err = wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		err := c.Get(ctx, deploymentName, &appsv1.Deployment{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("the Deployment [%s] is not gone: %w", deploymentName, err)
+	}
+
+	return nil
+}
+
+// Run removes the references we added to every ServiceAccount, and then deletes every managed Secret.
+func Run(ctx context.Context, c client.Client) error {
+	var errs []error
+
+	serviceAccounts := &corev1.ServiceAccountList{}
+
+	err := c.List(ctx, serviceAccounts)
+	if err != nil {
+		return fmt.Errorf("could not list the ServiceAccounts: %w", err)
+	}
+
+	for i := range serviceAccounts.Items {
+		err = RemoveInjectedSecrets(ctx, c, &serviceAccounts.Items[i])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+
+	err = c.List(ctx, secrets, client.MatchingLabels{
+		"app.kubernetes.io/name": "registry-secret-manager",
+		"registry-secret":        "true",
+	})
+	if err != nil {
+		return fmt.Errorf("could not list the Secrets: %w", err)
+	}
+
+	for i := range secrets.Items {
+		err = DeleteSecret(ctx, c, &secrets.Items[i])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return stderrors.Join(errs...)
+}
+
+// DeleteSecret deletes the Secret if it is managed by us.
+func DeleteSecret(ctx context.Context, c client.Client, existing *corev1.Secret) error {
+	if !isManaged(existing) {
+		return nil
+	}
+
+	err := c.Delete(ctx, existing, client.Preconditions{UID: &existing.UID})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete the Secret [%s/%s]: %w", existing.Namespace, existing.Name, err)
+	}
+
+	log.Infof("Deleted the Secret [%s/%s]", existing.Namespace, existing.Name)
+
+	return nil
+}
+
+// RemoveInjectedSecrets removes the references we added to the ServiceAccount, and the record of them. The references
+// the user added themselves are kept. When the ServiceAccount was mutated by an older version which didn't record them,
+// the references to our Secrets, or to Secrets which don't exist, are removed.
+func RemoveInjectedSecrets(ctx context.Context, c client.Client, serviceAccount *corev1.ServiceAccount) error {
+	injected, recorded := serviceaccount.InjectedSecrets(serviceAccount)
+
+	imagePullSecrets := make([]corev1.LocalObjectReference, 0, len(serviceAccount.ImagePullSecrets))
+
+	for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
+		remove, err := isInjected(ctx, c, serviceAccount.Namespace, imagePullSecret.Name, injected, recorded)
+		if err != nil {
+			return err
+		}
+
+		if !remove {
+			imagePullSecrets = append(imagePullSecrets, imagePullSecret)
+		}
+	}
+
+	if !recorded && len(imagePullSecrets) == len(serviceAccount.ImagePullSecrets) {
+		return nil
+	}
+
+	delete(serviceAccount.Annotations, serviceaccount.InjectedSecretsAnnotation)
+	serviceAccount.ImagePullSecrets = imagePullSecrets
+
+	err := c.Update(ctx, serviceAccount)
+	if err != nil {
+		return fmt.Errorf("could not update ServiceAccount [%s/%s]: %w", serviceAccount.Namespace, serviceAccount.Name, err)
+	}
+
+	log.Infof("Removed the Secrets from the ServiceAccount [%s/%s]", serviceAccount.Namespace, serviceAccount.Name)
+
+	return nil
+}
+
+// Returns whether the reference to the Secret was added by us.
+func isInjected(ctx context.Context, c client.Client, namespace, name string, injected []string, recorded bool) (bool, error) {
+	if !secret.IsManaged(name) {
+		return false, nil
+	}
+
+	if recorded {
+		for _, injectedName := range injected {
+			if injectedName == name {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	existing := &corev1.Secret{}
+
+	err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, existing)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not fetch the Secret [%s/%s]: %w", namespace, name, err)
+	}
+
+	return isManaged(existing), nil
+}
+
+// Returns whether the Secret is managed by us, by both its name and its labels.
+func isManaged(existing *corev1.Secret) bool {
+	return secret.IsManaged(existing.Name) &&
+		existing.Labels["app.kubernetes.io/name"] == "registry-secret-manager" &&
+		existing.Labels["registry-secret"] == "true"
+}
@@ -0,0 +1,153 @@
+package cleanup_test
+
+import (
+	"context"
+	"registry-secret-manager/pkg/cleanup"
+	"registry-secret-manager/pkg/serviceaccount"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newSecret(namespace, name string, managed bool) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+
+	if managed {
+		secret.Labels = map[string]string{
+			"app.kubernetes.io/name": "registry-secret-manager",
+			"registry-secret":        "true",
+		}
+	}
+
+	return secret
+}
+
+func newServiceAccount(namespace string, annotations map[string]string, imagePullSecrets ...string) *corev1.ServiceAccount {
+	var secrets []corev1.LocalObjectReference
+	for _, secretName := range imagePullSecrets {
+		secrets = append(secrets, corev1.LocalObjectReference{
+			Name: secretName,
+		})
+	}
+
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        "default",
+			Annotations: annotations,
+		},
+		ImagePullSecrets: secrets,
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		// Recorded by the mutator, the reference added by the user is kept
+		newServiceAccount("recorded", map[string]string{serviceaccount.InjectedSecretsAnnotation: "registry-secret-ecr"},
+			"first", "registry-secret", "registry-secret-ecr"),
+		newSecret("recorded", "registry-secret", true),
+		newSecret("recorded", "registry-secret-ecr", true),
+
+		// Mutated by an older version, only the references to our Secrets are removed
+		newServiceAccount("legacy", nil, "first", "registry-secret", "registry-secret-user"),
+		newSecret("legacy", "registry-secret", true),
+		newSecret("legacy", "registry-secret-user", false),
+
+		// Not ours
+		newSecret("other", "registry-secret", false),
+		newSecret("other", "unrelated", true),
+	).Build()
+
+	err := cleanup.Run(context.TODO(), fakeClient)
+
+	assert.NoError(t, err)
+
+	recorded := &corev1.ServiceAccount{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "recorded", Name: "default"}, recorded))
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "first"}, {Name: "registry-secret"}}, recorded.ImagePullSecrets)
+	assert.NotContains(t, recorded.Annotations, serviceaccount.InjectedSecretsAnnotation)
+
+	legacy := &corev1.ServiceAccount{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "legacy", Name: "default"}, legacy))
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "first"}, {Name: "registry-secret-user"}}, legacy.ImagePullSecrets)
+
+	for name, deleted := range map[types.NamespacedName]bool{
+		{Namespace: "recorded", Name: "registry-secret"}:     true,
+		{Namespace: "recorded", Name: "registry-secret-ecr"}: true,
+		{Namespace: "legacy", Name: "registry-secret"}:       true,
+		{Namespace: "legacy", Name: "registry-secret-user"}:  false,
+		{Namespace: "other", Name: "registry-secret"}:        false,
+		{Namespace: "other", Name: "unrelated"}:              false,
+	} {
+		err = fakeClient.Get(context.TODO(), name, &corev1.Secret{})
+		assert.Equal(t, deleted, errors.IsNotFound(err), name.String())
+	}
+}
+
+func TestStopManager(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "registry-secret-manager"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "tools", Name: "registry-secret-manager"}},
+	).Build()
+
+	err := cleanup.StopManager(context.TODO(), fakeClient, "tools")
+
+	assert.NoError(t, err)
+
+	err = fakeClient.Get(context.TODO(), client.ObjectKey{Name: "registry-secret-manager"}, &admissionregistrationv1.MutatingWebhookConfiguration{})
+	assert.True(t, errors.IsNotFound(err))
+
+	err = fakeClient.Get(context.TODO(), client.ObjectKey{Namespace: "tools", Name: "registry-secret-manager"}, &appsv1.Deployment{})
+	assert.True(t, errors.IsNotFound(err))
+
+	// Stopping an already stopped manager is fine
+	assert.NoError(t, cleanup.StopManager(context.TODO(), fakeClient, "tools"))
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		newServiceAccount("team", map[string]string{serviceaccount.InjectedSecretsAnnotation: "registry-secret"}, "registry-secret"),
+		newSecret("team", "registry-secret", true),
+	).Build()
+
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "default"}}
+	_, err := cleanup.NewServiceAccountReconciler(fakeClient).Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+
+	serviceAccount := &corev1.ServiceAccount{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), request.NamespacedName, serviceAccount))
+	assert.Empty(t, serviceAccount.ImagePullSecrets)
+
+	request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "registry-secret"}}
+	_, err = cleanup.NewSecretReconciler(fakeClient).Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), request.NamespacedName, &corev1.Secret{})))
+
+	// Objects which are already gone are skipped
+	_, err = cleanup.NewSecretReconciler(fakeClient).Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,68 @@
+package cleanup
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// NewController initializes the cleanup controllers, which keep on removing the managed Secrets and the references we
+// added to the ServiceAccounts instead of managing them.
+func NewController(mgr manager.Manager) error {
+	// Setup the reconcilers
+	secretController, err := controller.New("cleanup-secret", mgr, controller.Options{
+		Reconciler: NewSecretReconciler(mgr.GetClient()),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set up Secret cleanup controller: %w", err)
+	}
+
+	serviceAccountController, err := controller.New("cleanup-serviceaccount", mgr, controller.Options{
+		Reconciler: NewServiceAccountReconciler(mgr.GetClient()),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set up ServiceAccount cleanup controller: %w", err)
+	}
+
+	// Only handle the Secrets that matches these labels
+	labelSelector, err := predicate.LabelSelectorPredicate(metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app.kubernetes.io/name": "registry-secret-manager",
+			"registry-secret":        "true",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create label selector for Secrets: %w", err)
+	}
+
+	// Watch Secrets and ServiceAccounts, and enqueue their object key
+	err = secretController.Watch(
+		&source.Kind{
+			Type: &corev1.Secret{},
+		},
+		&handler.EnqueueRequestForObject{},
+		labelSelector,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to watch Secrets: %w", err)
+	}
+
+	err = serviceAccountController.Watch(
+		&source.Kind{
+			Type: &corev1.ServiceAccount{},
+		},
+		&handler.EnqueueRequestForObject{},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to watch ServiceAccounts: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type SecretReconciler struct {
+	client client.Client
+}
+
+func NewSecretReconciler(client client.Client) *SecretReconciler {
+	return &SecretReconciler{
+		client: client,
+	}
+}
+
+func (r *SecretReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log.Debugf("Received request to clean up Secret [%s]", request.NamespacedName)
+
+	existing := &corev1.Secret{}
+
+	err := r.client.Get(ctx, request.NamespacedName, existing)
+	if errors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("could not fetch the Secret [%s]: %w", request.NamespacedName, err)
+		log.Error(err)
+
+		return reconcile.Result{}, err
+	}
+
+	err = DeleteSecret(ctx, r.client, existing)
+	if err != nil {
+		log.Error(err)
+
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+type ServiceAccountReconciler struct {
+	client client.Client
+}
+
+func NewServiceAccountReconciler(client client.Client) *ServiceAccountReconciler {
+	return &ServiceAccountReconciler{
+		client: client,
+	}
+}
+
+func (r *ServiceAccountReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log.Debugf("Received request to clean up ServiceAccount [%s]", request.NamespacedName)
+
+	serviceAccount := &corev1.ServiceAccount{}
+
+	err := r.client.Get(ctx, request.NamespacedName, serviceAccount)
+	if errors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("could not fetch the ServiceAccount [%s]: %w", request.NamespacedName, err)
+		log.Error(err)
+
+		return reconcile.Result{}, err
+	}
+
+	err = RemoveInjectedSecrets(ctx, r.client, serviceAccount)
+	if err != nil {
+		log.Error(err)
+
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
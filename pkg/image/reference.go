@@ -0,0 +1,160 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DockerHubRegistry is the registry used for images without an explicit registry.
+	DockerHubRegistry = "docker.io"
+
+	// DockerHubNamespace is the namespace used for official Docker Hub images (eg: nginx).
+	DockerHubNamespace = "library"
+
+	// DefaultTag is used when an image has neither a tag nor a digest.
+	DefaultTag = "latest"
+
+	// DockerHubAPIHost serves the registry API of Docker Hub.
+	DockerHubAPIHost = "registry-1.docker.io"
+)
+
+// Reference represents a normalised container image reference.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Parse normalises an image reference the same way the container runtimes do, so "nginx" becomes
+// "docker.io/library/nginx:latest" and "localhost:5000/app@sha256:..." keeps its port and digest.
+func Parse(image string) (*Reference, error) {
+	if image == "" {
+		return nil, fmt.Errorf("image reference is empty")
+	}
+
+	reference := &Reference{}
+	name := image
+
+	// The digest always comes last and may contain a colon itself
+	if index := strings.Index(name, "@"); index >= 0 {
+		reference.Digest = name[index+1:]
+		name = name[:index]
+	}
+
+	// The tag is separated by the last colon, as long as it is not part of the registry port
+	if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		reference.Tag = name[index+1:]
+		name = name[:index]
+	}
+
+	if reference.Tag == "" && reference.Digest == "" {
+		reference.Tag = DefaultTag
+	}
+
+	// The first component is only a registry when it looks like a host, otherwise it's a Docker Hub namespace
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		reference.Registry = NormalizeHost(parts[0])
+		reference.Repository = parts[1]
+	} else {
+		reference.Registry = DockerHubRegistry
+		reference.Repository = name
+	}
+
+	if reference.Registry == DockerHubRegistry && !strings.Contains(reference.Repository, "/") {
+		reference.Repository = DockerHubNamespace + "/" + reference.Repository
+	}
+
+	if reference.Repository == "" || strings.HasSuffix(reference.Repository, "/") {
+		return nil, fmt.Errorf("image reference %s has an invalid repository", image)
+	}
+
+	if strings.ToLower(reference.Repository) != reference.Repository {
+		return nil, fmt.Errorf("image reference %s must have a lowercase repository", image)
+	}
+
+	return reference, nil
+}
+
+// Name returns the registry and repository of the image.
+func (r *Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the fully qualified image reference.
+func (r *Reference) String() string {
+	reference := r.Name()
+
+	if r.Tag != "" {
+		reference += ":" + r.Tag
+	}
+
+	if r.Digest != "" {
+		reference += "@" + r.Digest
+	}
+
+	return reference
+}
+
+// Matches checks if the image is pulled from the given registry endpoint. Endpoints may contain a path, in which case
+// only the repositories below that path match (eg: pull through cache prefixes).
+func (r *Reference) Matches(endpoint string) bool {
+	normalized := NormalizeEndpoint(endpoint)
+	if normalized == "" {
+		return false
+	}
+
+	name := r.Name()
+
+	return name == normalized || strings.HasPrefix(name, normalized+"/")
+}
+
+// NormalizeEndpoint strips the scheme and API version of a registry endpoint, so "https://index.docker.io/v1/"
+// becomes "docker.io" and "https://123456789012.dkr.ecr.eu-west-1.amazonaws.com" the bare host.
+func NormalizeEndpoint(endpoint string) string {
+	_, host, path := SplitEndpoint(endpoint)
+
+	return JoinEndpoint(NormalizeHost(host), path)
+}
+
+// SplitEndpoint returns the scheme, if any, the lowercased host and the path of a registry endpoint, without its API
+// version nor trailing slash. The Docker Hub aliases are kept as is, each consumer maps them to the name it needs.
+func SplitEndpoint(endpoint string) (string, string, string) {
+	var scheme string
+
+	normalized := strings.TrimSpace(endpoint)
+	if index := strings.Index(normalized, "://"); index >= 0 {
+		scheme, normalized = normalized[:index], normalized[index+3:]
+	}
+
+	normalized = strings.TrimSuffix(normalized, "/")
+	normalized = strings.TrimSuffix(normalized, "/v1")
+	normalized = strings.TrimSuffix(normalized, "/v2")
+
+	host, path, _ := strings.Cut(normalized, "/")
+
+	return scheme, strings.ToLower(host), path
+}
+
+// JoinEndpoint returns the endpoint of the given host and path.
+func JoinEndpoint(host, path string) string {
+	if path == "" {
+		return host
+	}
+
+	return host + "/" + path
+}
+
+// NormalizeHost lowercases the host and maps the Docker Hub aliases to a single name.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(host)
+
+	switch host {
+	case "index.docker.io", DockerHubAPIHost:
+		return DockerHubRegistry
+	}
+
+	return host
+}
@@ -0,0 +1,126 @@
+package image_test
+
+import (
+	"registry-secret-manager/pkg/image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		image    string
+		expected *image.Reference
+	}{
+		{
+			name:     "official docker hub image",
+			image:    "nginx",
+			expected: &image.Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name:     "docker hub image with namespace and tag",
+			image:    "bitnami/redis:7.0",
+			expected: &image.Reference{Registry: "docker.io", Repository: "bitnami/redis", Tag: "7.0"},
+		},
+		{
+			name:     "docker hub alias",
+			image:    "index.docker.io/nginx:1.25",
+			expected: &image.Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.25"},
+		},
+		{
+			name:     "registry with port",
+			image:    "localhost:5000/app",
+			expected: &image.Reference{Registry: "localhost:5000", Repository: "app", Tag: "latest"},
+		},
+		{
+			name:  "digest without tag",
+			image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/team/app@sha256:0123abcd",
+			expected: &image.Reference{
+				Registry:   "123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+				Repository: "team/app",
+				Digest:     "sha256:0123abcd",
+			},
+		},
+		{
+			name:  "tag and digest",
+			image: "ghcr.io/werkspot/app:1.0@sha256:0123abcd",
+			expected: &image.Reference{
+				Registry:   "ghcr.io",
+				Repository: "werkspot/app",
+				Tag:        "1.0",
+				Digest:     "sha256:0123abcd",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			reference, err := image.Parse(test.image)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, reference)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, invalid := range []string{"", "ghcr.io/", "Nginx"} {
+		_, err := image.Parse(invalid)
+
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		image    string
+		endpoint string
+		expected bool
+	}{
+		{image: "nginx", endpoint: "https://index.docker.io/v1/", expected: true},
+		{image: "bitnami/redis", endpoint: "registry-1.docker.io", expected: true},
+		{image: "quay.io/prometheus/prometheus", endpoint: "https://index.docker.io/v1/", expected: false},
+		{image: "localhost:5000/app", endpoint: "localhost:5000", expected: true},
+		{image: "localhost:5000/app", endpoint: "localhost:5001", expected: false},
+		{image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app", endpoint: "https://123456789012.dkr.ecr.eu-west-1.amazonaws.com", expected: true},
+		{image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hub/nginx", endpoint: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hub", expected: true},
+		{image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hubby/nginx", endpoint: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hub", expected: false},
+	}
+
+	for _, test := range tests {
+		reference, err := image.Parse(test.image)
+
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, reference.Matches(test.endpoint), "%s on %s", test.image, test.endpoint)
+	}
+}
+
+func TestSplitEndpoint(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][3]string{
+		"https://index.docker.io/v1/":                             {"https", "index.docker.io", ""},
+		"registry-1.docker.io":                                    {"", "registry-1.docker.io", ""},
+		"https://Harbor.Example.com:8443/v2/":                     {"https", "harbor.example.com:8443", ""},
+		"http://127.0.0.1:5000":                                   {"http", "127.0.0.1:5000", ""},
+		"GHCR.io/Werkspot/":                                       {"", "ghcr.io", "Werkspot"},
+		"123456789012.dkr.ecr.eu-west-1.amazonaws.com/docker-hub": {"", "123456789012.dkr.ecr.eu-west-1.amazonaws.com", "docker-hub"},
+	}
+
+	for endpoint, expected := range tests {
+		scheme, host, path := image.SplitEndpoint(endpoint)
+
+		assert.Equal(t, expected, [3]string{scheme, host, path}, endpoint)
+		assert.Equal(t, image.JoinEndpoint(image.NormalizeHost(host), path), image.NormalizeEndpoint(endpoint), endpoint)
+	}
+}
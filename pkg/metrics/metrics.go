@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "registry_secret_manager"
+
+var (
+	// WebhookDuration measures how long the webhooks take to respond to an admission request.
+	WebhookDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "webhook_duration_seconds",
+		Help:      "Time taken by the webhooks to respond to an admission request",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"webhook"})
+
+	// SecretQueueDepth measures the amount of Secrets waiting to be created.
+	SecretQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secret_queue_depth",
+		Help:      "Amount of Secrets waiting to be created",
+	})
+
+	// PullFailureRefreshes counts the refreshes of the Secrets requested after Pods failed to pull their images.
+	PullFailureRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pull_failure_refreshes_total",
+		Help:      "Amount of Secret refreshes requested after Pods failed to pull their images",
+	})
+
+	// DockerHubRateLimit measures the amount of pulls allowed by Docker Hub per window.
+	DockerHubRateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "docker_hub_ratelimit_limit",
+		Help:      "Amount of pulls allowed by Docker Hub per window",
+	})
+
+	// DockerHubRateLimitRemaining measures the amount of pulls remaining on Docker Hub in the current window.
+	DockerHubRateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "docker_hub_ratelimit_remaining",
+		Help:      "Amount of pulls remaining on Docker Hub in the current window",
+	})
+)
+
+func init() {
+	// Register the metrics with the controller-runtime registry, so they are served next to its own metrics
+	metrics.Registry.MustRegister(
+		WebhookDuration,
+		SecretQueueDepth,
+		PullFailureRefreshes,
+		DockerHubRateLimit,
+		DockerHubRateLimitRemaining,
+	)
+}
+
+// ObserveWebhookDuration records the time elapsed since start for the given webhook.
+func ObserveWebhookDuration(webhook string, start time.Time) {
+	WebhookDuration.WithLabelValues(webhook).Observe(time.Since(start).Seconds())
+}
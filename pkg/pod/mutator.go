@@ -0,0 +1,114 @@
+package pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"registry-secret-manager/pkg/metrics"
+	"registry-secret-manager/pkg/secret"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// CreateTimeout bounds the creation of the missing Secrets, so the admission of the Pod doesn't time out.
+var CreateTimeout = 3 * time.Second
+
+type Mutator struct {
+	creator  secret.Creator
+	enqueuer secret.Enqueuer
+	secrets  map[string]string
+
+	decoder *admission.Decoder
+}
+
+// NewMutator returns a Mutator injecting the Secret holding the credentials of each registry endpoint the Pods pull
+// their images from.
+func NewMutator(creator secret.Creator, enqueuer secret.Enqueuer, secrets map[string]string) *Mutator {
+	return &Mutator{
+		creator:  creator,
+		enqueuer: enqueuer,
+		secrets:  secrets,
+	}
+}
+
+func (m *Mutator) Handle(ctx context.Context, request admission.Request) admission.Response {
+	defer metrics.ObserveWebhookDuration("pod", time.Now())
+
+	log.Debugf("Received request to mutate Pod [%s/%s]", request.Namespace, request.Name)
+
+	// Decode the Pod from the request
+	pod := &corev1.Pod{}
+
+	err := m.decoder.Decode(request, pod)
+	if err != nil {
+		err = fmt.Errorf("failed to decode Pod [%s/%s]: %w", request.Namespace, request.Name, err)
+		log.Error(err)
+
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// Only Pods pulling images from one of our registries need the Secrets of those registries
+	names := matchingSecrets(pod, m.secrets)
+	if len(names) == 0 {
+		reason := fmt.Sprintf("No images from managed registries in Pod [%s/%s]", request.Namespace, request.Name)
+		log.Debug(reason)
+
+		return admission.Allowed(reason)
+	}
+
+	// Mutate the Pod if needed
+	missing := missingSecrets(pod, names)
+	if len(missing) == 0 {
+		reason := fmt.Sprintf("No mutation needed for Pod [%s/%s]", request.Namespace, request.Name)
+		log.Debug(reason)
+
+		return admission.Allowed(reason)
+	}
+
+	// Create the secret before the Pod references it, but a dry-run request must not have any side effects
+	if !secret.IsDryRun(request) {
+		m.createSecrets(ctx, request.Namespace)
+	}
+
+	// Patch the Pod with the secret
+	log.Infof("Responding with a patch to Pod [%s/%s]", request.Namespace, request.Name)
+
+	for _, name := range missing {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{
+			Name: name,
+		})
+	}
+
+	patched, err := json.Marshal(pod)
+	if err != nil {
+		err = fmt.Errorf("failed to marshall Pod [%s/%s]: %w", request.Namespace, request.Name, err)
+		log.Error(err)
+
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(request.Object.Raw, patched)
+}
+
+// Creates the Secrets on the namespace, or requests their creation in the background when it doesn't succeed in time.
+// The kubelet keeps on retrying to pull the images until the Secrets exist.
+func (m *Mutator) createSecrets(ctx context.Context, namespace string) {
+	ctx, cancel := context.WithTimeout(ctx, CreateTimeout)
+	defer cancel()
+
+	err := m.creator.Create(ctx, namespace)
+	if err != nil {
+		log.Warnf("Creating the Secret on namespace [%s] in the background: %v", namespace, err)
+		m.enqueuer.Enqueue(namespace)
+	}
+}
+
+func (m *Mutator) InjectDecoder(decoder *admission.Decoder) error {
+	m.decoder = decoder
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package pod_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"registry-secret-manager/pkg/pod"
+	"registry-secret-manager/pkg/secret"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var secrets = map[string]string{
+	"https://index.docker.io/v1/":                          secret.Name,
+	"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com": secret.Name,
+}
+
+type fakeQueue struct {
+	err error
+
+	mutex    sync.Mutex
+	created  []string
+	enqueued []string
+}
+
+func (f *fakeQueue) Create(_ context.Context, namespace string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.created = append(f.created, namespace)
+
+	return f.err
+}
+
+func (f *fakeQueue) Enqueue(namespace string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.enqueued = append(f.enqueued, namespace)
+}
+
+func TestHandle(t *testing.T) {
+	t.Parallel()
+
+	jsonPatchType := admissionv1.PatchTypeJSONPatch
+
+	tests := []struct {
+		name       string
+		mustCreate bool
+		createErr  error
+		dryRun     bool
+		target     *corev1.Pod
+		patchType  *admissionv1.PatchType
+		patch      []jsonpatch.JsonPatchOperation
+	}{
+		{
+			name:   "no images from managed registries",
+			target: newPod([]string{"quay.io/prometheus/prometheus:v2.41.0"}, nil),
+		},
+		{
+			name:   "no patch needed",
+			target: newPod([]string{"nginx:latest"}, []string{"registry-secret"}),
+		},
+		{
+			name:       "docker hub image",
+			mustCreate: true,
+			target:     newPod([]string{"nginx:latest"}, nil),
+			patchType:  &jsonPatchType,
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
+			}},
+		},
+		{
+			name:       "docker hub image on an init container",
+			mustCreate: true,
+			target:     withInitContainer(newPod([]string{"quay.io/prometheus/prometheus:v2.41.0"}, nil), "busybox@sha256:0123abcd"),
+			patchType:  &jsonPatchType,
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
+			}},
+		},
+		{
+			name:       "ecr image next to a secret not managed by us",
+			mustCreate: true,
+			target:     newPod([]string{"123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0"}, []string{"not-managed-by-us"}),
+			patchType:  &jsonPatchType,
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets/1",
+				Value:     map[string]interface{}{"name": "registry-secret"},
+			}},
+		},
+		{
+			name:       "image which can't be parsed",
+			mustCreate: true,
+			target:     newPod([]string{"quay.io/prometheus/prometheus:v2.41.0", "Nginx"}, nil),
+			patchType:  &jsonPatchType,
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
+			}},
+		},
+		{
+			name:       "docker hub image when the creation of the secret fails",
+			mustCreate: true,
+			createErr:  errors.New("registry unavailable"),
+			target:     newPod([]string{"nginx:latest"}, nil),
+			patchType:  &jsonPatchType,
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
+			}},
+		},
+		{
+			name:      "docker hub image on dry-run",
+			dryRun:    true,
+			target:    newPod([]string{"nginx:latest"}, nil),
+			patchType: &jsonPatchType,
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			queue := &fakeQueue{err: test.createErr}
+			assertMutate(t, queue, secrets, test.target, test.patchType, test.patch, test.dryRun)
+
+			// The secret is created when the Pod got patched, and in the background when that fails
+			switch {
+			case !test.mustCreate:
+				assert.Empty(t, queue.created)
+				assert.Empty(t, queue.enqueued)
+			case test.createErr != nil:
+				assert.Equal(t, []string{"registry-secret-manager"}, queue.created)
+				assert.Equal(t, []string{"registry-secret-manager"}, queue.enqueued)
+			default:
+				assert.Equal(t, []string{"registry-secret-manager"}, queue.created)
+				assert.Empty(t, queue.enqueued)
+			}
+		})
+	}
+}
+
+func assertMutate(t *testing.T, queue *fakeQueue, secrets map[string]string, target *corev1.Pod, patchType *admissionv1.PatchType, patch []jsonpatch.JsonPatchOperation, dryRun bool) {
+	t.Helper()
+
+	mutator := pod.NewMutator(queue, queue, secrets)
+
+	decoder, _ := admission.NewDecoder(scheme.Scheme)
+	_ = mutator.InjectDecoder(decoder)
+
+	// Submit the request and verify the response
+	podJSON, err := json.Marshal(target)
+	assert.NoError(t, err)
+
+	request := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			Namespace: "registry-secret-manager",
+			Name:      "pod",
+			Object:    runtime.RawExtension{Raw: podJSON},
+			DryRun:    &dryRun,
+		},
+	}
+	response := mutator.Handle(context.TODO(), request)
+
+	assert.True(t, response.Allowed)
+	assert.Equal(t, patchType, response.PatchType)
+	assert.Equal(t, patch, response.Patches)
+}
+
+func TestHandlePerRegistry(t *testing.T) {
+	t.Parallel()
+
+	jsonPatchType := admissionv1.PatchTypeJSONPatch
+	perRegistry := map[string]string{
+		"https://index.docker.io/v1/":                          "registry-secret-docker-hub",
+		"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com": "registry-secret-ecr",
+	}
+
+	tests := []struct {
+		name   string
+		target *corev1.Pod
+		patch  []jsonpatch.JsonPatchOperation
+	}{
+		{
+			name:   "docker hub image",
+			target: newPod([]string{"nginx:latest"}, nil),
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value:     []interface{}{map[string]interface{}{"name": "registry-secret-docker-hub"}},
+			}},
+		},
+		{
+			name:   "ecr image next to the docker hub secret",
+			target: newPod([]string{"123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0"}, []string{"registry-secret-docker-hub"}),
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets/1",
+				Value:     map[string]interface{}{"name": "registry-secret-ecr"},
+			}},
+		},
+		{
+			name:   "image which can't be parsed",
+			target: newPod([]string{"Nginx"}, nil),
+			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/imagePullSecrets",
+				Value: []interface{}{
+					map[string]interface{}{"name": "registry-secret-docker-hub"},
+					map[string]interface{}{"name": "registry-secret-ecr"},
+				},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Only the Secrets of the registries the images are pulled from are injected
+			assertMutate(t, &fakeQueue{}, perRegistry, test.target, &jsonPatchType, test.patch, false)
+		})
+	}
+}
+
+func TestHandleCreatesSecret(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().Build()
+	queue := secret.NewQueue(fakeClient, nil, secret.Options{})
+	mutator := pod.NewMutator(queue, queue, secrets)
+
+	decoder, _ := admission.NewDecoder(scheme.Scheme)
+	_ = mutator.InjectDecoder(decoder)
+
+	podJSON, err := json.Marshal(newPod([]string{"nginx:latest"}, nil))
+	assert.NoError(t, err)
+
+	response := mutator.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			Namespace: "registry-secret-manager",
+			Name:      "pod",
+			Object:    runtime.RawExtension{Raw: podJSON},
+		},
+	})
+	assert.True(t, response.Allowed)
+
+	// The secret referenced by the patched Pod already exists, without the queue being processed
+	secretName := types.NamespacedName{Namespace: "registry-secret-manager", Name: secret.Name}
+	assert.NoError(t, fakeClient.Get(context.TODO(), secretName, &corev1.Secret{}))
+}
+
+func newPod(images []string, imagePullSecrets []string) *corev1.Pod {
+	var containers []corev1.Container
+	for i, image := range images {
+		containers = append(containers, corev1.Container{
+			Name:  fmt.Sprintf("container-%d", i),
+			Image: image,
+		})
+	}
+
+	var secrets []corev1.LocalObjectReference
+	for _, secretName := range imagePullSecrets {
+		secrets = append(secrets, corev1.LocalObjectReference{
+			Name: secretName,
+		})
+	}
+
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "registry-secret-manager",
+			Name:      "pod",
+		},
+		Spec: corev1.PodSpec{
+			Containers:       containers,
+			ImagePullSecrets: secrets,
+		},
+	}
+}
+
+func withInitContainer(pod *corev1.Pod, image string) *corev1.Pod {
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		Name:  "init",
+		Image: image,
+	})
+
+	return pod
+}
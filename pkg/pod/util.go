@@ -0,0 +1,72 @@
+package pod
+
+import (
+	"registry-secret-manager/pkg/image"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Returns the Secrets missing on the Pod.
+func missingSecrets(pod *corev1.Pod, names []string) []string {
+	var missing []string
+
+	for _, name := range names {
+		present := false
+
+		for _, imagePullSecret := range pod.Spec.ImagePullSecrets {
+			if imagePullSecret.Name == name {
+				present = true
+
+				break
+			}
+		}
+
+		if !present {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// Returns the sorted Secrets of the endpoints that any of the images of both the containers and the init containers is
+// pulled from.
+func matchingSecrets(pod *corev1.Pod, secrets map[string]string) []string {
+	var containers []corev1.Container
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+
+	matching := map[string]bool{}
+
+	for _, container := range containers {
+		reference, err := image.Parse(container.Image)
+		if err != nil {
+			// The API server doesn't validate the image references, the Pod gets every Secret in case the runtime pulls
+			// the image from one of our registries after all
+			log.Warnf("Injecting the Secrets as the image of container [%s] can't be parsed: %v", container.Name, err)
+
+			for _, name := range secrets {
+				matching[name] = true
+			}
+
+			continue
+		}
+
+		for endpoint, name := range secrets {
+			if reference.Matches(endpoint) {
+				matching[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(matching))
+	for name := range matching {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,17 @@
+package pod
+
+import (
+	"registry-secret-manager/pkg/secret"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// NewWebhook registers a webhook that injects the Secrets directly into Pods, given the Secret holding the credentials of
+// each registry endpoint.
+func NewWebhook(mgr manager.Manager, creator secret.Creator, enqueuer secret.Enqueuer, secrets map[string]string) {
+	server := mgr.GetWebhookServer()
+	server.Register("/mutate-pod", &webhook.Admission{
+		Handler: NewMutator(creator, enqueuer, secrets),
+	})
+}
@@ -0,0 +1,54 @@
+package pullfailure
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// NewController initializes a controller watching the Events of failed image pulls, which refreshes the Secret of the
+// namespace at most once per interval when the pull failed because of the credentials.
+func NewController(mgr manager.Manager, interval time.Duration) error {
+	// Setup the reconciler
+	pullFailureController, err := controller.New("pullfailure", mgr, controller.Options{
+		Reconciler: NewReconciler(mgr.GetClient(), mgr.GetEventRecorderFor("registry-secret-manager"), interval),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set up pull failure controller: %w", err)
+	}
+
+	// Only handle the Events of pulls failing because of the credentials, they are updated when the pull fails again
+	isAuthorizationFailure := func(object client.Object) bool {
+		pullFailure, ok := object.(*corev1.Event)
+
+		return ok && IsAuthorizationFailure(pullFailure)
+	}
+
+	// Watch Events and enqueue Event object key
+	err = pullFailureController.Watch(
+		&source.Kind{
+			Type: &corev1.Event{},
+		},
+		&handler.EnqueueRequestForObject{},
+		predicate.NewPredicateFuncs(isAuthorizationFailure),
+		predicate.Funcs{
+			DeleteFunc: func(event event.DeleteEvent) bool {
+				return false
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to watch Events: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package pullfailure
+
+import (
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of the Events recorded by the kubelet when it fails to pull an image.
+var Reasons = []string{"Failed", "ErrImagePull"}
+
+// AuthorizationFailures are the lowercase fragments of the pull failure messages caused by missing, expired or invalid
+// credentials, as reported by the container runtimes and the registries.
+var AuthorizationFailures = []string{
+	"401 unauthorized",
+	"403 forbidden",
+	"access denied",
+	"authentication required",
+	"authorization failed",
+	"no basic auth credentials",
+	"pull access denied",
+	"unauthorized",
+}
+
+// Matches the image of a pull failure message, eg: `Failed to pull image "nginx:latest": rpc error: ...`.
+var imagePattern = regexp.MustCompile(`image "([^"]+)"`)
+
+// IsAuthorizationFailure returns whether the Event reports a Pod failing to pull an image because of its credentials.
+func IsAuthorizationFailure(event *corev1.Event) bool {
+	if event.InvolvedObject.Kind != "Pod" || !isPullFailure(event.Reason) {
+		return false
+	}
+
+	message := strings.ToLower(event.Message)
+
+	for _, failure := range AuthorizationFailures {
+		if strings.Contains(message, failure) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImageOf returns the image the Event reports a failed pull of.
+func ImageOf(event *corev1.Event) (string, bool) {
+	matches := imagePattern.FindStringSubmatch(event.Message)
+	if matches == nil {
+		return "", false
+	}
+
+	return matches[1], true
+}
+
+func isPullFailure(reason string) bool {
+	for _, pullFailure := range Reasons {
+		if reason == pullFailure {
+			return true
+		}
+	}
+
+	return false
+}
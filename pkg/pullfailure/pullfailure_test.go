@@ -0,0 +1,77 @@
+package pullfailure_test
+
+import (
+	"registry-secret-manager/pkg/pullfailure"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newEvent(kind, reason, message string) *corev1.Event {
+	return &corev1.Event{
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: "app"},
+		Reason:         reason,
+		Message:        message,
+	}
+}
+
+func TestIsAuthorizationFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		event    *corev1.Event
+		expected bool
+	}{
+		{
+			name: "containerd unauthorized",
+			event: newEvent("Pod", "Failed", `Failed to pull image "ghcr.io/werkspot/app:1.0": rpc error: code = Unknown `+
+				`desc = failed to pull and unpack image "ghcr.io/werkspot/app:1.0": failed to resolve reference: `+
+				`failed to authorize: failed to fetch anonymous token: unexpected status: 401 Unauthorized`),
+			expected: true,
+		},
+		{
+			name:     "docker pull access denied",
+			event:    newEvent("Pod", "Failed", `Failed to pull image "werkspot/app": Error response from daemon: pull access denied for werkspot/app`),
+			expected: true,
+		},
+		{
+			name:     "ecr no basic auth credentials",
+			event:    newEvent("Pod", "ErrImagePull", `Failed to pull image "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app": no basic auth credentials`),
+			expected: true,
+		},
+		{
+			name:     "image not found",
+			event:    newEvent("Pod", "Failed", `Failed to pull image "ghcr.io/werkspot/app:2.0": not found`),
+			expected: false,
+		},
+		{
+			name:     "back-off",
+			event:    newEvent("Pod", "BackOff", `Back-off pulling image "ghcr.io/werkspot/app:1.0": unauthorized`),
+			expected: false,
+		},
+		{
+			name:     "not a Pod",
+			event:    newEvent("Node", "Failed", `unauthorized`),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, pullfailure.IsAuthorizationFailure(test.event), test.name)
+	}
+}
+
+func TestImageOf(t *testing.T) {
+	t.Parallel()
+
+	image, ok := pullfailure.ImageOf(newEvent("Pod", "Failed", `Failed to pull image "ghcr.io/werkspot/app:1.0": unauthorized`))
+
+	assert.True(t, ok)
+	assert.Equal(t, "ghcr.io/werkspot/app:1.0", image)
+
+	_, ok = pullfailure.ImageOf(newEvent("Pod", "Failed", `unauthorized`))
+
+	assert.False(t, ok)
+}
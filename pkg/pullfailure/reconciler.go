@@ -0,0 +1,180 @@
+package pullfailure
+
+import (
+	"context"
+	"fmt"
+	"registry-secret-manager/pkg/image"
+	"registry-secret-manager/pkg/metrics"
+	"registry-secret-manager/pkg/secret"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RefreshRequestedReason is the reason of the Events recorded on the Secrets refreshed after a failed pull.
+const RefreshRequestedReason = "RefreshRequested"
+
+type Reconciler struct {
+	client   client.Client
+	recorder record.EventRecorder
+	interval time.Duration
+
+	mutex       sync.Mutex
+	refreshedAt map[types.NamespacedName]time.Time
+}
+
+func NewReconciler(client client.Client, recorder record.EventRecorder, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		client:      client,
+		recorder:    recorder,
+		interval:    interval,
+		refreshedAt: map[types.NamespacedName]time.Time{},
+	}
+}
+
+func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	log.Debugf("Received request to reconcile Event [%s]", request.NamespacedName)
+
+	// Fetch the Event from cache
+	pullFailure := &corev1.Event{}
+
+	err := r.client.Get(ctx, request.NamespacedName, pullFailure)
+	if errors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("could not fetch the Event [%s]: %w", request.NamespacedName, err)
+		log.Error(err)
+
+		return reconcile.Result{}, err
+	}
+
+	// The Events of the pulls which failed before the last refresh could have happened are stale, eg: on startup
+	if !IsAuthorizationFailure(pullFailure) || time.Since(lastSeen(pullFailure)) > r.interval {
+		return reconcile.Result{}, nil
+	}
+
+	pulled, ok := ImageOf(pullFailure)
+	if !ok {
+		log.Debugf("Ignoring the Event [%s] without image", request.NamespacedName)
+
+		return reconcile.Result{}, nil
+	}
+
+	reference, err := image.Parse(pulled)
+	if err != nil {
+		log.Debugf("Ignoring the Event [%s]: %v", request.NamespacedName, err)
+
+		return reconcile.Result{}, nil
+	}
+
+	// Refresh the Secrets holding the credentials of the image registry
+	secrets, err := secret.ListManagedSecrets(ctx, r.client, pullFailure.Namespace)
+	if err != nil {
+		err = fmt.Errorf("could not list the Secrets of the namespace [%s]: %w", pullFailure.Namespace, err)
+		log.Error(err)
+
+		return reconcile.Result{}, err
+	}
+
+	for i := range secrets {
+		if !holdsCredentials(&secrets[i], reference) {
+			continue
+		}
+
+		err = r.refresh(ctx, &secrets[i], pullFailure, reference)
+		if err != nil {
+			log.Error(err)
+
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// Requests the refresh of the Secret, unless it was already refreshed within the interval.
+func (r *Reconciler) refresh(ctx context.Context, existing *corev1.Secret, pullFailure *corev1.Event, reference *image.Reference) error {
+	secretName := types.NamespacedName{Namespace: existing.Namespace, Name: existing.Name}
+
+	if r.isRateLimited(secretName) {
+		log.Debugf("Not refreshing the Secret [%s] again after the Pod [%s] failed to pull %s", secretName, pullFailure.InvolvedObject.Name, reference)
+
+		return nil
+	}
+
+	err := secret.RequestRefresh(ctx, r.client, existing, time.Now())
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.refreshedAt[secretName] = time.Now()
+	r.mutex.Unlock()
+
+	metrics.PullFailureRefreshes.Inc()
+
+	r.recorder.Eventf(existing, corev1.EventTypeNormal, RefreshRequestedReason,
+		"Refreshing the credentials as the Pod %s failed to pull %s", pullFailure.InvolvedObject.Name, reference)
+
+	log.Infof("Refreshing the Secret [%s] as the Pod [%s] failed to pull %s", secretName, pullFailure.InvolvedObject.Name, reference)
+
+	return nil
+}
+
+// Returns whether the Secret was refreshed within the interval, and forgets the Secrets refreshed before.
+func (r *Reconciler) isRateLimited(secretName types.NamespacedName) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for name, refreshedAt := range r.refreshedAt {
+		if time.Since(refreshedAt) > r.interval {
+			delete(r.refreshedAt, name)
+		}
+	}
+
+	_, ok := r.refreshedAt[secretName]
+
+	return ok
+}
+
+// Returns whether the Secret holds credentials for the registry of the image.
+func holdsCredentials(existing *corev1.Secret, reference *image.Reference) bool {
+	dockerConfig, err := secret.DecodeSecret(existing)
+	if err != nil {
+		log.Debugf("Ignoring the Secret [%s/%s]: %v", existing.Namespace, existing.Name, err)
+
+		return false
+	}
+
+	for _, endpoint := range dockerConfig.Keys() {
+		if reference.Matches(endpoint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns when the pull failed for the last time.
+func lastSeen(pullFailure *corev1.Event) time.Time {
+	switch {
+	case pullFailure.Series != nil:
+		return pullFailure.Series.LastObservedTime.Time
+	case !pullFailure.LastTimestamp.IsZero():
+		return pullFailure.LastTimestamp.Time
+	case !pullFailure.EventTime.IsZero():
+		return pullFailure.EventTime.Time
+	default:
+		return pullFailure.CreationTimestamp.Time
+	}
+}
@@ -0,0 +1,89 @@
+package pullfailure_test
+
+import (
+	"context"
+	"registry-secret-manager/pkg/pullfailure"
+	"registry-secret-manager/pkg/secret"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newPullFailure(name, image string, lastTimestamp time.Time) *corev1.Event {
+	pullFailure := newEvent("Pod", "Failed", `Failed to pull image "`+image+`": 401 Unauthorized`)
+	pullFailure.Namespace = "team"
+	pullFailure.Name = name
+	pullFailure.LastTimestamp = metav1.NewTime(lastTimestamp)
+
+	return pullFailure
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	secretName := types.NamespacedName{Namespace: "team", Name: secret.Name}
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: secretName.Namespace,
+				Name:      secretName.Name,
+				Labels: map[string]string{
+					"app.kubernetes.io/name": "registry-secret-manager",
+					"registry-secret":        "true",
+				},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"ghcr.io":{"auth":"dXNlcjpwYXNz"}}}`),
+			},
+		},
+		newPullFailure("stale", "ghcr.io/werkspot/app:1.0", time.Now().Add(-time.Hour)),
+		newPullFailure("other-registry", "quay.io/werkspot/app:1.0", time.Now()),
+		newPullFailure("first", "ghcr.io/werkspot/app:1.0", time.Now()),
+		newPullFailure("second", "ghcr.io/werkspot/worker:1.0", time.Now()),
+	).Build()
+
+	recorder := record.NewFakeRecorder(10)
+	reconciler := pullfailure.NewReconciler(fakeClient, recorder, 10*time.Minute)
+
+	reconcileEvent := func(name string) *corev1.Secret {
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: "team", Name: name},
+		})
+		assert.NoError(t, err)
+
+		reconciled := &corev1.Secret{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), secretName, reconciled))
+
+		return reconciled
+	}
+
+	// Neither stale pull failures nor the ones of other registries trigger a refresh
+	assert.NotContains(t, reconcileEvent("stale").Annotations, secret.RefreshAnnotation)
+	assert.NotContains(t, reconcileEvent("other-registry").Annotations, secret.RefreshAnnotation)
+	assert.NotContains(t, reconcileEvent("missing").Annotations, secret.RefreshAnnotation)
+	assert.Empty(t, recorder.Events)
+
+	// The first pull failure triggers a refresh, which is recorded
+	refreshed := reconcileEvent("first")
+
+	assert.Contains(t, refreshed.Annotations, secret.RefreshAnnotation)
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, pullfailure.RefreshRequestedReason)
+
+	// The next ones within the interval don't
+	assert.NoError(t, fakeClient.Patch(context.TODO(), refreshed, client.RawPatch(types.MergePatchType,
+		[]byte(`{"metadata":{"annotations":{"`+secret.RefreshAnnotation+`":null}}}`))))
+	assert.NotContains(t, reconcileEvent("second").Annotations, secret.RefreshAnnotation)
+	assert.Empty(t, recorder.Events)
+}
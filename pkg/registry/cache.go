@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache wraps a Registry and reuses its Credentials, so a login is only performed once per TTL.
+type Cache struct {
+	registry Registry
+	ttl      time.Duration
+
+	mutex       sync.Mutex
+	credentials []*Credentials
+	loggedInAt  time.Time
+	expiresAt   time.Time
+}
+
+// NewCache returns a pointer to Cache.
+func NewCache(registry Registry, ttl time.Duration) *Cache {
+	return &Cache{
+		registry: registry,
+		ttl:      ttl,
+	}
+}
+
+// Login returns the cached Credentials, or performs a new login when they expired.
+func (c *Cache) Login() ([]*Credentials, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.credentials != nil && time.Now().Before(c.expiresAt) {
+		return c.credentials, nil
+	}
+
+	credentials, err := c.registry.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	// Credentials expiring before the TTL are renewed ahead of their expiry
+	c.credentials = credentials
+	c.loggedInAt = time.Now()
+	c.expiresAt = c.loggedInAt.Add(c.ttl)
+
+	if expiresAt := EarliestExpiry(credentials); !expiresAt.IsZero() && expiresAt.Add(-ExpiryMargin).Before(c.expiresAt) {
+		c.expiresAt = expiresAt.Add(-ExpiryMargin)
+	}
+
+	return credentials, nil
+}
+
+// Invalidate the cached Credentials, forcing a new login on the next call.
+func (c *Cache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.credentials = nil
+}
+
+// InvalidateBefore invalidates the cached Credentials when they were obtained before the given time, so a refresh
+// requested for many Secrets at once only performs a single login.
+func (c *Cache) InvalidateBefore(before time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.loggedInAt.Before(before) {
+		c.credentials = nil
+	}
+}
+
+// Unwrap returns the cached Registry.
+func (c *Cache) Unwrap() Registry {
+	return c.registry
+}
@@ -0,0 +1,139 @@
+package registry_test
+
+import (
+	"fmt"
+	"registry-secret-manager/pkg/registry"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubRegistry returns its credentials, or new credentials on every login when it has none, counting the logins.
+type stubRegistry struct {
+	credentials []*registry.Credentials
+	err         error
+	logins      int
+}
+
+func (r *stubRegistry) Login() ([]*registry.Credentials, error) {
+	r.logins++
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if r.credentials != nil {
+		return r.credentials, nil
+	}
+
+	return []*registry.Credentials{registry.NewCredentials("user", fmt.Sprintf("pass-%d", r.logins), "https://foo.bar")}, nil
+}
+
+func TestCacheLogin(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{}
+	cache := registry.NewCache(stub, time.Hour)
+
+	first, err := cache.Login()
+	assert.NoError(t, err)
+
+	second, err := cache.Login()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, stub.logins)
+	assert.Equal(t, first, second)
+
+	// Invalidating forces a new login
+	cache.Invalidate()
+
+	third, err := cache.Login()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, stub.logins)
+	assert.Equal(t, "pass-2", third[0].Password)
+}
+
+func TestCacheExpired(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{}
+	cache := registry.NewCache(stub, 0)
+
+	_, _ = cache.Login()
+	_, _ = cache.Login()
+
+	assert.Equal(t, 2, stub.logins)
+}
+
+func TestCacheError(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{err: fmt.Errorf("registry unavailable")}
+	cache := registry.NewCache(stub, time.Hour)
+
+	_, err := cache.Login()
+	assert.Error(t, err)
+
+	// Errors are never cached
+	_, err = cache.Login()
+	assert.Error(t, err)
+
+	assert.Equal(t, 2, stub.logins)
+}
+
+func expiringIn(expiresIn time.Duration) []*registry.Credentials {
+	credentials := registry.NewCredentials("user", "pass", "https://foo.bar")
+	credentials.ExpiresAt = time.Now().Add(expiresIn)
+
+	return []*registry.Credentials{credentials}
+}
+
+func TestCacheCredentialsExpiry(t *testing.T) {
+	t.Parallel()
+
+	// Credentials expiring within the margin are never reused, even though the TTL didn't pass yet
+	stub := &stubRegistry{credentials: expiringIn(registry.ExpiryMargin / 2)}
+	cache := registry.NewCache(stub, time.Hour)
+
+	_, _ = cache.Login()
+	_, _ = cache.Login()
+
+	assert.Equal(t, 2, stub.logins)
+
+	// Credentials expiring after the TTL are reused
+	stub = &stubRegistry{credentials: expiringIn(2 * time.Hour)}
+	cache = registry.NewCache(stub, time.Hour)
+
+	_, _ = cache.Login()
+	_, _ = cache.Login()
+
+	assert.Equal(t, 1, stub.logins)
+}
+
+func TestInvalidateBefore(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{}
+	named := registry.NewNamed(registry.NewEmail(registry.NewCache(stub, time.Hour), "registry@example.com"), "ecr")
+
+	first, err := named.Login()
+	assert.NoError(t, err)
+
+	// Credentials obtained after the refresh was requested are kept
+	registry.InvalidateBefore(named, time.Now().Add(-time.Minute))
+
+	second, err := named.Login()
+	assert.NoError(t, err)
+	assert.Equal(t, first[0].Password, second[0].Password)
+
+	registry.InvalidateBefore(named, time.Now().Add(time.Millisecond))
+
+	third, err := named.Login()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first[0].Password, third[0].Password)
+
+	// Registries which aren't cached are left as is
+	registry.InvalidateBefore(stub, time.Now())
+}
@@ -1,10 +1,28 @@
 package registry
 
+import "time"
+
+// ExpiryMargin is how long before their expiry Credentials are renewed.
+const ExpiryMargin = 10 * time.Minute
+
 // Credentials represents a credentials object.
 type Credentials struct {
 	Username string
 	Password string
 	Endpoint string
+	// Email is omitted from the docker config when empty.
+	Email string
+	// Aliases are other names the registry is reached through, eg: depending on the container runtime.
+	Aliases []string
+
+	// IdentityToken is a refresh token of the registry token service, preferred over the password by clients
+	// supporting it.
+	IdentityToken string
+	// RegistryToken is a bearer token presented as is to the registry.
+	RegistryToken string
+
+	// ExpiresAt is the zero time for credentials that never expire.
+	ExpiresAt time.Time
 }
 
 // NewCredentials returns a pointer to Credentials.
@@ -15,3 +33,20 @@ func NewCredentials(username, password, endpoint string) *Credentials {
 		Endpoint: endpoint,
 	}
 }
+
+// EarliestExpiry returns the earliest expiry of the given Credentials, or the zero time when none of them expire.
+func EarliestExpiry(registryCredentials []*Credentials) time.Time {
+	var earliest time.Time
+
+	for _, credentials := range registryCredentials {
+		if credentials.ExpiresAt.IsZero() {
+			continue
+		}
+
+		if earliest.IsZero() || credentials.ExpiresAt.Before(earliest) {
+			earliest = credentials.ExpiresAt
+		}
+	}
+
+	return earliest
+}
@@ -1,23 +1,79 @@
 package registry
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"registry-secret-manager/pkg/metrics"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DockerHubName contains a unique name.
+	DockerHubName = "docker-hub"
+
+	// DockerHubLoginURL validates both personal and organization access tokens.
+	DockerHubLoginURL = "https://hub.docker.com/v2/auth/token"
+
+	// DockerHubAuthURL hands out the registry tokens used to check the rate limit.
+	DockerHubAuthURL = "https://auth.docker.io/token"
+
+	// DockerHubRegistryURL serves the rate limit headers.
+	DockerHubRegistryURL = "https://registry-1.docker.io"
+
+	// DockerHubRateLimitRepository is the repository Docker provides to check the rate limit, checking it on this
+	// repository doesn't count as a pull.
+	DockerHubRateLimitRepository = "ratelimitpreview/test"
 )
 
-// DockerHubName contains a unique name.
-const DockerHubName = "docker-hub"
+// DockerHubAliases are the names Docker Hub is looked up by, besides the configured endpoint.
+var DockerHubAliases = []string{"docker.io", "index.docker.io", "registry-1.docker.io"}
+
+// DockerHubConfig holds the (overridable) endpoints of Docker Hub.
+type DockerHubConfig struct {
+	LoginURL    string `mapstructure:"login-url"`
+	AuthURL     string `mapstructure:"auth-url"`
+	RegistryURL string `mapstructure:"registry-url"`
+
+	// RateLimitWarning is the amount of remaining pulls below which a warning is logged.
+	RateLimitWarning int `mapstructure:"rate-limit-warning"`
+}
 
 // DockerHub represents a Docker Hub Registry.
-type DockerHub struct{}
+type DockerHub struct {
+	config DockerHubConfig
+	client *http.Client
+}
+
+// NewDockerHub returns a pointer to DockerHub, using the public Docker Hub endpoints unless overridden.
+func NewDockerHub(config DockerHubConfig) *DockerHub {
+	if config.LoginURL == "" {
+		config.LoginURL = DockerHubLoginURL
+	}
+
+	if config.AuthURL == "" {
+		config.AuthURL = DockerHubAuthURL
+	}
 
-// NewDockerHub returns a pointer to DockerHub.
-func NewDockerHub() *DockerHub {
-	return &DockerHub{}
+	if config.RegistryURL == "" {
+		config.RegistryURL = DockerHubRegistryURL
+	}
+
+	return &DockerHub{
+		config: config,
+		client: newHTTPClient(),
+	}
 }
 
 // Login returns a valid Credentials pointer and/or error.
-func (d *DockerHub) Login() (*Credentials, error) {
+func (d *DockerHub) Login() ([]*Credentials, error) {
 	username, err := d.retrieveEnvVar("DOCKER_HUB_USERNAME")
 	if err != nil {
 		return nil, err
@@ -33,7 +89,23 @@ func (d *DockerHub) Login() (*Credentials, error) {
 		return nil, err
 	}
 
-	return NewCredentials(username, password, endpoint), nil
+	// The password is either the account password, a personal access token or an organization access token (in which
+	// case the username is the organization name). Either way Docker Hub must accept it.
+	err = d.validate(username, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate the Docker Hub credentials: %w", err)
+	}
+
+	// The rate limit is informative only, failing to retrieve it must not prevent the credentials from being used
+	err = d.checkRateLimit(username, password)
+	if err != nil {
+		log.Warnf("Failed to check the Docker Hub rate limit: %v", err)
+	}
+
+	credentials := NewCredentials(username, password, endpoint)
+	credentials.Aliases = DockerHubAliases
+
+	return []*Credentials{credentials}, nil
 }
 
 func (d *DockerHub) retrieveEnvVar(key string) (string, error) {
@@ -48,3 +120,106 @@ func (d *DockerHub) retrieveEnvVar(key string) (string, error) {
 
 	return value, nil
 }
+
+func (d *DockerHub) validate(username, password string) error {
+	body, err := json.Marshal(map[string]string{
+		"identifier": username,
+		"secret":     password,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshall the login request: %w", err)
+	}
+
+	request, err := newRequest(http.MethodPost, d.config.LoginURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = doRequest(d.client, request, http.StatusOK, nil)
+	if err != nil {
+		return fmt.Errorf("failed to login: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DockerHub) checkRateLimit(username, password string) error {
+	// Retrieve a registry token for the rate limit repository
+	query := url.Values{}
+	query.Set("service", "registry.docker.io")
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", DockerHubRateLimitRepository))
+
+	request, err := newRequest(http.MethodGet, d.config.AuthURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(username, password)
+
+	token := struct {
+		Token string `json:"token"`
+	}{}
+
+	_, err = doRequest(d.client, request, http.StatusOK, &token)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve a registry token: %w", err)
+	}
+
+	// A HEAD request on the manifest returns the rate limit headers without consuming a pull
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/latest", d.config.RegistryURL, DockerHubRateLimitRepository)
+
+	request, err = newRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+token.Token)
+
+	// The rate limit headers are returned as well once the limit is reached
+	header, err := doRequest(d.client, request, http.StatusOK, nil)
+
+	var rejected *statusError
+	if errors.As(err, &rejected) {
+		header, err = rejected.header, nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to retrieve the manifest: %w", err)
+	}
+
+	// Accounts without a rate limit don't return the headers at all
+	remaining, present := parseRateLimitHeader(header.Get("ratelimit-remaining"))
+	if !present {
+		log.Debugf("No Docker Hub rate limit applies to [%s]", username)
+
+		return nil
+	}
+
+	if limit, present := parseRateLimitHeader(header.Get("ratelimit-limit")); present {
+		metrics.DockerHubRateLimit.Set(float64(limit))
+	}
+
+	metrics.DockerHubRateLimitRemaining.Set(float64(remaining))
+
+	if remaining < d.config.RateLimitWarning {
+		log.Warnf("Docker Hub account [%s] is close to its rate limit, only %d pulls remaining", username, remaining)
+	}
+
+	return nil
+}
+
+// Parses rate limit headers, which look like "76;w=21600" (76 pulls in a window of 21600 seconds).
+func parseRateLimitHeader(header string) (int, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(header, ";", 2)[0]))
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
+}
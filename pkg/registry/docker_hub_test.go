@@ -0,0 +1,122 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"registry-secret-manager/pkg/metrics"
+	"registry-secret-manager/pkg/registry"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a stand-in for the Docker Hub login, auth and registry endpoints.
+func newFakeDockerHubServer(t *testing.T, password string, rateLimitHeaders map[string]string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/auth/token", func(writer http.ResponseWriter, request *http.Request) {
+		body := map[string]string{}
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+
+		if body["identifier"] != "user" || body["secret"] != password {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte(`{"access_token":"hub-token"}`))
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "repository:ratelimitpreview/test:pull", request.URL.Query().Get("scope"))
+
+		_, _ = writer.Write([]byte(`{"token":"registry-token"}`))
+	})
+	mux.HandleFunc("/v2/ratelimitpreview/test/manifests/latest", func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, http.MethodHead, request.Method)
+		assert.Equal(t, "Bearer registry-token", request.Header.Get("Authorization"))
+
+		for key, value := range rateLimitHeaders {
+			writer.Header().Set(key, value)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newFakeDockerHub(server *httptest.Server) *registry.DockerHub {
+	return registry.NewDockerHub(registry.DockerHubConfig{
+		LoginURL:         server.URL + "/v2/auth/token",
+		AuthURL:          server.URL + "/token",
+		RegistryURL:      server.URL,
+		RateLimitWarning: 20,
+	})
+}
+
+func setDockerHubEnv(t *testing.T, password string) {
+	t.Helper()
+
+	t.Setenv("DOCKER_HUB_USERNAME", "user")
+	t.Setenv("DOCKER_HUB_PASSWORD", password)
+	t.Setenv("DOCKER_HUB_ENDPOINT", "https://index.docker.io/v1/")
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestDockerHubLogin(t *testing.T) {
+	setDockerHubEnv(t, "dckr_pat_token")
+
+	server := newFakeDockerHubServer(t, "dckr_pat_token", map[string]string{
+		"ratelimit-limit":     "200;w=21600",
+		"ratelimit-remaining": "76;w=21600",
+	})
+
+	credentials, err := newFakeDockerHub(server).Login()
+
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 1)
+	assert.Equal(t, "user", credentials[0].Username)
+	assert.Equal(t, "dckr_pat_token", credentials[0].Password)
+	assert.Equal(t, "https://index.docker.io/v1/", credentials[0].Endpoint)
+	assert.Equal(t, registry.DockerHubAliases, credentials[0].Aliases)
+	assert.Equal(t, float64(200), testutil.ToFloat64(metrics.DockerHubRateLimit))
+	assert.Equal(t, float64(76), testutil.ToFloat64(metrics.DockerHubRateLimitRemaining))
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestDockerHubLoginWithoutRateLimit(t *testing.T) {
+	setDockerHubEnv(t, "dckr_oat_token")
+
+	server := newFakeDockerHubServer(t, "dckr_oat_token", nil)
+
+	credentials, err := newFakeDockerHub(server).Login()
+
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 1)
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestDockerHubLoginInvalidCredentials(t *testing.T) {
+	setDockerHubEnv(t, "revoked")
+
+	server := newFakeDockerHubServer(t, "dckr_pat_token", nil)
+
+	_, err := newFakeDockerHub(server).Login()
+
+	assert.Error(t, err)
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestDockerHubLoginMissingEnvVar(t *testing.T) {
+	setDockerHubEnv(t, "")
+
+	server := newFakeDockerHubServer(t, "", nil)
+
+	_, err := newFakeDockerHub(server).Login()
+
+	assert.Error(t, err)
+}
@@ -5,42 +5,156 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	sess "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
 )
 
 // EcrName contains a unique name.
 const EcrName = "ecr"
 
+// EcrAccount represents the ECR registry of an AWS account in a given region.
+type EcrAccount struct {
+	// RegistryID is the AWS account ID, it defaults to the account of the credentials.
+	RegistryID string `mapstructure:"registry-id"`
+	// Region defaults to the region of the environment (eg: AWS_REGION).
+	Region string `mapstructure:"region"`
+	// RoleARN is assumed via STS before logging in, when set.
+	RoleARN string `mapstructure:"role-arn"`
+	// Endpoint overrides the ECR API endpoint (eg: VPC endpoints or a local stand-in).
+	Endpoint string `mapstructure:"endpoint"`
+	// PullThroughCachePrefixes get their own entry (eg: "<registry>/docker-hub"), so images mirrored by a pull
+	// through cache rule are matched even when the bare registry is not used.
+	PullThroughCachePrefixes []string `mapstructure:"pull-through-cache-prefixes"`
+}
+
+// EcrClientFactory returns an ECR API client for the given account.
+type EcrClientFactory func(account EcrAccount) (ecriface.ECRAPI, error)
+
 // ECR represents an ECR Registry.
-type ECR struct{}
+type ECR struct {
+	accounts  []EcrAccount
+	newClient EcrClientFactory
+}
+
+// NewECR returns a pointer to ECR. Without any accounts, the default account and region of the environment are used.
+func NewECR(accounts []EcrAccount, newClient EcrClientFactory) *ECR {
+	if len(accounts) == 0 {
+		accounts = []EcrAccount{{}}
+	}
 
-// NewECR returns a pointer to ECR.
-func NewECR() *ECR {
-	return &ECR{}
+	return &ECR{
+		accounts:  accounts,
+		newClient: newClient,
+	}
 }
 
-// Login returns a valid Credentials pointer and/or error.
-func (e *ECR) Login() (*Credentials, error) {
-	session, err := sess.NewSession()
+// NewEcrClient returns an ECR API client using the default credential chain, optionally assuming the account role. The
+// endpoint only applies to the ECR client, the role is assumed through the STS endpoint of the region.
+func NewEcrClient(account EcrAccount) (ecriface.ECRAPI, error) {
+	sessionConfig := aws.NewConfig()
+	if account.Region != "" {
+		sessionConfig = sessionConfig.WithRegion(account.Region)
+	}
+
+	session, err := sess.NewSession(sessionConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new session: %w", err)
 	}
 
-	service := ecr.New(session)
+	config := aws.NewConfig()
+	if account.Endpoint != "" {
+		config = config.WithEndpoint(account.Endpoint)
+	}
+
+	if account.RoleARN != "" {
+		config = config.WithCredentials(stscreds.NewCredentials(session, account.RoleARN))
+	}
+
+	return ecr.New(session, config), nil
+}
+
+// Login returns one Credentials pointer per account and/or error.
+func (e *ECR) Login() ([]*Credentials, error) {
+	var registryCredentials []*Credentials
+
+	for _, account := range e.accounts {
+		credentials, err := e.login(account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to login on ECR registry [%s] in region [%s]: %w", account.RegistryID, account.Region, err)
+		}
+
+		registryCredentials = append(registryCredentials, credentials...)
+	}
+
+	return registryCredentials, nil
+}
+
+func (e *ECR) login(account EcrAccount) ([]*Credentials, error) {
+	service, err := e.newClient(account)
+	if err != nil {
+		return nil, err
+	}
+
 	input := &ecr.GetAuthorizationTokenInput{}
+	if account.RegistryID != "" {
+		input.RegistryIds = aws.StringSlice([]string{account.RegistryID})
+	}
 
 	token, err := service.GetAuthorizationToken(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get authorization token: %w", err)
 	}
 
-	decodedBytes, err := base64.StdEncoding.DecodeString(*token.AuthorizationData[0].AuthorizationToken)
+	if len(token.AuthorizationData) == 0 {
+		return nil, fmt.Errorf("no authorization data returned")
+	}
+
+	var registryCredentials []*Credentials
+
+	for _, authorizationData := range token.AuthorizationData {
+		if authorizationData == nil || authorizationData.ProxyEndpoint == nil {
+			return nil, fmt.Errorf("authorization data without a proxy endpoint returned")
+		}
+
+		username, password, err := decodeAuthorizationToken(aws.StringValue(authorizationData.AuthorizationToken))
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint := aws.StringValue(authorizationData.ProxyEndpoint)
+		endpoints := []string{endpoint}
+
+		for _, prefix := range account.PullThroughCachePrefixes {
+			endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/")+"/"+strings.Trim(prefix, "/"))
+		}
+
+		// The token is valid for 12 hours, the Secrets are refreshed before it expires
+		for _, e := range endpoints {
+			credentials := NewCredentials(username, password, e)
+			credentials.ExpiresAt = aws.TimeValue(authorizationData.ExpiresAt)
+
+			registryCredentials = append(registryCredentials, credentials)
+		}
+	}
+
+	return registryCredentials, nil
+}
+
+// Decodes the "username:password" authorization token returned by both ECR and ECR Public.
+func decodeAuthorizationToken(token string) (string, string, error) {
+	decodedBytes, err := base64.StdEncoding.DecodeString(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to base64 decode the token: %w", err)
+		return "", "", fmt.Errorf("failed to base64 decode the token: %w", err)
 	}
 
-	parts := strings.Split(string(decodedBytes), ":")
+	// Only the first colon separates the username, the password may contain colons itself
+	parts := strings.SplitN(string(decodedBytes), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("the token is not in the username:password format")
+	}
 
-	return NewCredentials(parts[0], parts[1], *token.AuthorizationData[0].ProxyEndpoint), nil
+	return parts[0], parts[1], nil
 }
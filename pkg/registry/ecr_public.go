@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	sess "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecrpublic"
+	"github.com/aws/aws-sdk-go/service/ecrpublic/ecrpubliciface"
+)
+
+const (
+	// EcrPublicName contains a unique name.
+	EcrPublicName = "ecr-public"
+
+	// EcrPublicEndpoint is the single endpoint of every ECR Public repository.
+	EcrPublicEndpoint = "public.ecr.aws"
+
+	// EcrPublicRegion is the only region where ECR Public hands out authorization tokens.
+	EcrPublicRegion = "us-east-1"
+)
+
+// EcrPublicClientFactory returns an ECR Public API client.
+type EcrPublicClientFactory func() (ecrpubliciface.ECRPublicAPI, error)
+
+// EcrPublic represents the ECR Public Registry.
+type EcrPublic struct {
+	newClient EcrPublicClientFactory
+}
+
+// NewEcrPublic returns a pointer to EcrPublic.
+func NewEcrPublic(newClient EcrPublicClientFactory) *EcrPublic {
+	return &EcrPublic{
+		newClient: newClient,
+	}
+}
+
+// NewEcrPublicClient returns an ECR Public API client using the default credential chain.
+func NewEcrPublicClient() (ecrpubliciface.ECRPublicAPI, error) {
+	session, err := sess.NewSession(aws.NewConfig().WithRegion(EcrPublicRegion))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new session: %w", err)
+	}
+
+	return ecrpublic.New(session), nil
+}
+
+// Login returns a valid Credentials pointer and/or error.
+func (e *EcrPublic) Login() ([]*Credentials, error) {
+	service, err := e.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := service.GetAuthorizationToken(&ecrpublic.GetAuthorizationTokenInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization token: %w", err)
+	}
+
+	if token.AuthorizationData == nil {
+		return nil, fmt.Errorf("no authorization data returned")
+	}
+
+	username, password, err := decodeAuthorizationToken(aws.StringValue(token.AuthorizationData.AuthorizationToken))
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := NewCredentials(username, password, EcrPublicEndpoint)
+	credentials.ExpiresAt = aws.TimeValue(token.AuthorizationData.ExpiresAt)
+
+	return []*Credentials{credentials}, nil
+}
@@ -0,0 +1,69 @@
+package registry_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"registry-secret-manager/pkg/registry"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecrpublic"
+	"github.com/aws/aws-sdk-go/service/ecrpublic/ecrpubliciface"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubEcrPublicClient struct {
+	ecrpubliciface.ECRPublicAPI
+
+	output *ecrpublic.GetAuthorizationTokenOutput
+}
+
+func (s *stubEcrPublicClient) GetAuthorizationToken(*ecrpublic.GetAuthorizationTokenInput) (*ecrpublic.GetAuthorizationTokenOutput, error) {
+	return s.output, nil
+}
+
+func TestEcrPublicLogin(t *testing.T) {
+	t.Parallel()
+
+	ecrPublic := registry.NewEcrPublic(func() (ecrpubliciface.ECRPublicAPI, error) {
+		return &stubEcrPublicClient{
+			output: &ecrpublic.GetAuthorizationTokenOutput{
+				AuthorizationData: &ecrpublic.AuthorizationData{
+					AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("AWS:password"))),
+					ExpiresAt:          aws.Time(ecrExpiresAt),
+				},
+			},
+		}, nil
+	})
+
+	credentials, err := ecrPublic.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{
+		newEcrCredentials("password", "public.ecr.aws", ecrExpiresAt),
+	}, credentials)
+}
+
+func TestEcrPublicLoginWithoutAuthorizationData(t *testing.T) {
+	t.Parallel()
+
+	ecrPublic := registry.NewEcrPublic(func() (ecrpubliciface.ECRPublicAPI, error) {
+		return &stubEcrPublicClient{output: &ecrpublic.GetAuthorizationTokenOutput{}}, nil
+	})
+
+	_, err := ecrPublic.Login()
+
+	assert.Error(t, err)
+}
+
+func TestEcrPublicLoginClientError(t *testing.T) {
+	t.Parallel()
+
+	ecrPublic := registry.NewEcrPublic(func() (ecrpubliciface.ECRPublicAPI, error) {
+		return nil, fmt.Errorf("no credentials")
+	})
+
+	_, err := ecrPublic.Login()
+
+	assert.Error(t, err)
+}
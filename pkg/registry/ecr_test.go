@@ -0,0 +1,387 @@
+package registry_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"registry-secret-manager/pkg/registry"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	sess "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/stretchr/testify/assert"
+)
+
+// ecrExpiresAt is when the tokens handed out by the stub ECR client expire.
+var ecrExpiresAt = time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newEcrCredentials(password, endpoint string, expiresAt time.Time) *registry.Credentials {
+	credentials := registry.NewCredentials("AWS", password, endpoint)
+	credentials.ExpiresAt = expiresAt
+
+	return credentials
+}
+
+type stubEcrClient struct {
+	ecriface.ECRAPI
+
+	account registry.EcrAccount
+	err     error
+}
+
+func (s *stubEcrClient) GetAuthorizationToken(input *ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	registryID := "000000000000"
+	if len(input.RegistryIds) > 0 {
+		registryID = aws.StringValue(input.RegistryIds[0])
+	}
+
+	token := base64.StdEncoding.EncodeToString([]byte("AWS:password-" + registryID))
+	endpoint := fmt.Sprintf("https://%s.dkr.ecr.%s.amazonaws.com", registryID, s.account.Region)
+
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []*ecr.AuthorizationData{{
+			AuthorizationToken: aws.String(token),
+			ProxyEndpoint:      aws.String(endpoint),
+			ExpiresAt:          aws.Time(ecrExpiresAt),
+		}},
+	}, nil
+}
+
+func TestEcrLogin(t *testing.T) {
+	t.Parallel()
+
+	var roles []string
+
+	ecrRegistry := registry.NewECR(
+		[]registry.EcrAccount{
+			{RegistryID: "111111111111", Region: "eu-west-1"},
+			{RegistryID: "222222222222", Region: "us-east-1", RoleARN: "arn:aws:iam::222222222222:role/pull"},
+		},
+		func(account registry.EcrAccount) (ecriface.ECRAPI, error) {
+			roles = append(roles, account.RoleARN)
+
+			return &stubEcrClient{account: account}, nil
+		},
+	)
+
+	credentials, err := ecrRegistry.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{
+		newEcrCredentials("password-111111111111", "https://111111111111.dkr.ecr.eu-west-1.amazonaws.com", ecrExpiresAt),
+		newEcrCredentials("password-222222222222", "https://222222222222.dkr.ecr.us-east-1.amazonaws.com", ecrExpiresAt),
+	}, credentials)
+	assert.Equal(t, []string{"", "arn:aws:iam::222222222222:role/pull"}, roles)
+}
+
+func TestEcrLoginDefaultAccount(t *testing.T) {
+	t.Parallel()
+
+	ecrRegistry := registry.NewECR(nil, func(account registry.EcrAccount) (ecriface.ECRAPI, error) {
+		return &stubEcrClient{account: registry.EcrAccount{Region: "eu-west-1"}}, nil
+	})
+
+	credentials, err := ecrRegistry.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{
+		newEcrCredentials("password-000000000000", "https://000000000000.dkr.ecr.eu-west-1.amazonaws.com", ecrExpiresAt),
+	}, credentials)
+}
+
+func TestEcrLoginError(t *testing.T) {
+	t.Parallel()
+
+	ecrRegistry := registry.NewECR(
+		[]registry.EcrAccount{
+			{RegistryID: "111111111111", Region: "eu-west-1"},
+			{RegistryID: "222222222222", Region: "us-east-1"},
+		},
+		func(account registry.EcrAccount) (ecriface.ECRAPI, error) {
+			if account.RegistryID == "222222222222" {
+				return &stubEcrClient{account: account, err: fmt.Errorf("access denied")}, nil
+			}
+
+			return &stubEcrClient{account: account}, nil
+		},
+	)
+
+	_, err := ecrRegistry.Login()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "222222222222")
+}
+
+func TestEcrLoginPullThroughCache(t *testing.T) {
+	t.Parallel()
+
+	ecrRegistry := registry.NewECR(
+		[]registry.EcrAccount{
+			{RegistryID: "111111111111", Region: "eu-west-1", PullThroughCachePrefixes: []string{"docker-hub", "/quay/"}},
+		},
+		func(account registry.EcrAccount) (ecriface.ECRAPI, error) {
+			return &stubEcrClient{account: account}, nil
+		},
+	)
+
+	credentials, err := ecrRegistry.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{
+		newEcrCredentials("password-111111111111", "https://111111111111.dkr.ecr.eu-west-1.amazonaws.com", ecrExpiresAt),
+		newEcrCredentials("password-111111111111", "https://111111111111.dkr.ecr.eu-west-1.amazonaws.com/docker-hub", ecrExpiresAt),
+		newEcrCredentials("password-111111111111", "https://111111111111.dkr.ecr.eu-west-1.amazonaws.com/quay", ecrExpiresAt),
+	}, credentials)
+}
+
+// Serves a fake ECR API that responds to GetAuthorizationToken with the given status and body.
+func newFakeEcrServer(t *testing.T, status int, body string, requests *[]map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", request.Header.Get("X-Amz-Target"))
+
+		if requests != nil {
+			payload := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&payload))
+
+			*requests = append(*requests, payload)
+		}
+
+		writer.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newFakeEcrClientFactory(server *httptest.Server) registry.EcrClientFactory {
+	return func(account registry.EcrAccount) (ecriface.ECRAPI, error) {
+		session, err := sess.NewSession(aws.NewConfig().
+			WithRegion(account.Region).
+			WithEndpoint(server.URL).
+			WithMaxRetries(0).
+			WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+		if err != nil {
+			return nil, err
+		}
+
+		return ecr.New(session), nil
+	}
+}
+
+func encodeToken(token string) string {
+	return base64.StdEncoding.EncodeToString([]byte(token))
+}
+
+func TestEcrLoginFakeEndpoint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected []*registry.Credentials
+	}{
+		{
+			name:   "password containing colons",
+			status: http.StatusOK,
+			body: `{"authorizationData":[{` +
+				`"authorizationToken":"` + encodeToken("AWS:pass:word:") + `",` +
+				`"proxyEndpoint":"https://111111111111.dkr.ecr.eu-west-1.amazonaws.com",` +
+				`"expiresAt":1700000000}]}`,
+			expected: []*registry.Credentials{
+				newEcrCredentials("pass:word:", "https://111111111111.dkr.ecr.eu-west-1.amazonaws.com", time.Unix(1700000000, 0).UTC()),
+			},
+		},
+		{
+			name:   "multiple registries",
+			status: http.StatusOK,
+			body: `{"authorizationData":[` +
+				`{"authorizationToken":"` + encodeToken("AWS:one") + `","proxyEndpoint":"https://111111111111.dkr.ecr.eu-west-1.amazonaws.com"},` +
+				`{"authorizationToken":"` + encodeToken("AWS:two") + `","proxyEndpoint":"https://222222222222.dkr.ecr.eu-west-1.amazonaws.com"}]}`,
+			expected: []*registry.Credentials{
+				registry.NewCredentials("AWS", "one", "https://111111111111.dkr.ecr.eu-west-1.amazonaws.com"),
+				registry.NewCredentials("AWS", "two", "https://222222222222.dkr.ecr.eu-west-1.amazonaws.com"),
+			},
+		},
+		{
+			name:   "no authorization data",
+			status: http.StatusOK,
+			body:   `{"authorizationData":[]}`,
+		},
+		{
+			name:   "missing proxy endpoint",
+			status: http.StatusOK,
+			body:   `{"authorizationData":[{"authorizationToken":"` + encodeToken("AWS:password") + `"}]}`,
+		},
+		{
+			name:   "token without a colon",
+			status: http.StatusOK,
+			body:   `{"authorizationData":[{"authorizationToken":"` + encodeToken("AWS") + `","proxyEndpoint":"https://foo.bar"}]}`,
+		},
+		{
+			name:   "token with an empty password",
+			status: http.StatusOK,
+			body:   `{"authorizationData":[{"authorizationToken":"` + encodeToken("AWS:") + `","proxyEndpoint":"https://foo.bar"}]}`,
+		},
+		{
+			name:   "token not base64 encoded",
+			status: http.StatusOK,
+			body:   `{"authorizationData":[{"authorizationToken":"AWS:password","proxyEndpoint":"https://foo.bar"}]}`,
+		},
+		{
+			name:   "access denied",
+			status: http.StatusBadRequest,
+			body:   `{"__type":"AccessDeniedException","message":"not authorized to perform ecr:GetAuthorizationToken"}`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newFakeEcrServer(t, test.status, test.body, nil)
+			ecrRegistry := registry.NewECR(
+				[]registry.EcrAccount{{Region: "eu-west-1"}},
+				newFakeEcrClientFactory(server),
+			)
+
+			credentials, err := ecrRegistry.Login()
+
+			if test.expected == nil {
+				assert.Error(t, err)
+				assert.Nil(t, credentials)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, credentials)
+		})
+	}
+}
+
+func TestEcrLoginFakeEndpointRegistryIds(t *testing.T) {
+	t.Parallel()
+
+	var requests []map[string]interface{}
+
+	body := `{"authorizationData":[{"authorizationToken":"` + encodeToken("AWS:password") + `","proxyEndpoint":"https://foo.bar"}]}`
+	server := newFakeEcrServer(t, http.StatusOK, body, &requests)
+
+	ecrRegistry := registry.NewECR(
+		[]registry.EcrAccount{{Region: "eu-west-1"}, {RegistryID: "111111111111", Region: "eu-west-1"}},
+		newFakeEcrClientFactory(server),
+	)
+
+	_, err := ecrRegistry.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{},
+		{"registryIds": []interface{}{"111111111111"}},
+	}, requests)
+}
+
+//nolint:paralleltest // Uses environment variables for the default credential chain
+func TestNewEcrClientEndpointOverride(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	body := `{"authorizationData":[{"authorizationToken":"` + encodeToken("AWS:password") + `","proxyEndpoint":"https://foo.bar"}]}`
+	server := newFakeEcrServer(t, http.StatusOK, body, nil)
+
+	ecrRegistry := registry.NewECR(
+		[]registry.EcrAccount{{Region: "eu-west-1", Endpoint: server.URL}},
+		registry.NewEcrClient,
+	)
+
+	credentials, err := ecrRegistry.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{registry.NewCredentials("AWS", "password", "https://foo.bar")}, credentials)
+}
+
+// Sends the requests to the AWS endpoints to the given server instead.
+type awsEndpointsTransport struct {
+	server *httptest.Server
+}
+
+func (a *awsEndpointsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(request.URL.Hostname(), ".amazonaws.com") {
+		request.URL.Scheme = "http"
+		request.URL.Host = strings.TrimPrefix(a.server.URL, "http://")
+	}
+
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+//nolint:paralleltest // Uses environment variables for the default credential chain, and the default HTTP client
+func TestNewEcrClientAssumeRoleWithEndpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	var assumedRoles []string
+
+	stsServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.NoError(t, request.ParseForm())
+		assert.Equal(t, "AssumeRole", request.PostForm.Get("Action"))
+
+		assumedRoles = append(assumedRoles, request.PostForm.Get("RoleArn"))
+
+		writer.Header().Set("Content-Type", "text/xml")
+		_, _ = writer.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>` +
+			`<Credentials><AccessKeyId>role-id</AccessKeyId><SecretAccessKey>role-secret</SecretAccessKey>` +
+			`<SessionToken>role-token</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration></Credentials>` +
+			`<AssumedRoleUser><Arn>arn:aws:sts::210987654321:assumed-role/ecr/session</Arn><AssumedRoleId>role</AssumedRoleId></AssumedRoleUser>` +
+			`</AssumeRoleResult></AssumeRoleResponse>`))
+	}))
+	defer stsServer.Close()
+
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = &awsEndpointsTransport{server: stsServer}
+
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+
+	// The ECR server only ever receives the GetAuthorizationToken requests, signed with the credentials of the role
+	body := `{"authorizationData":[{"authorizationToken":"` + encodeToken("AWS:password") + `","proxyEndpoint":"https://foo.bar"}]}`
+	ecrServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", request.Header.Get("X-Amz-Target"))
+		assert.Contains(t, request.Header.Get("Authorization"), "Credential=role-id/")
+
+		writer.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_, _ = writer.Write([]byte(body))
+	}))
+	defer ecrServer.Close()
+
+	ecrRegistry := registry.NewECR(
+		[]registry.EcrAccount{{
+			Region:   "eu-west-1",
+			RoleARN:  "arn:aws:iam::210987654321:role/ecr",
+			Endpoint: ecrServer.URL,
+		}},
+		registry.NewEcrClient,
+	)
+
+	credentials, err := ecrRegistry.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{registry.NewCredentials("AWS", "password", "https://foo.bar")}, credentials)
+	assert.Equal(t, []string{"arn:aws:iam::210987654321:role/ecr"}, assumedRoles)
+}
@@ -0,0 +1,39 @@
+package registry
+
+// Email wraps a Registry and sets the email of its Credentials, as some older clients require one.
+type Email struct {
+	registry Registry
+	email    string
+}
+
+// NewEmail returns a pointer to Email.
+func NewEmail(registry Registry, email string) *Email {
+	return &Email{
+		registry: registry,
+		email:    email,
+	}
+}
+
+// Login returns copies of the Credentials of the wrapped Registry with the email set, as they may be shared by a Cache.
+func (e *Email) Login() ([]*Credentials, error) {
+	credentials, err := e.registry.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	withEmail := make([]*Credentials, 0, len(credentials))
+
+	for _, c := range credentials {
+		copied := *c
+		copied.Email = e.email
+
+		withEmail = append(withEmail, &copied)
+	}
+
+	return withEmail, nil
+}
+
+// Unwrap returns the wrapped Registry.
+func (e *Email) Unwrap() Registry {
+	return e.registry
+}
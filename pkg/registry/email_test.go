@@ -0,0 +1,50 @@
+package registry_test
+
+import (
+	"fmt"
+	"registry-secret-manager/pkg/registry"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailLogin(t *testing.T) {
+	t.Parallel()
+
+	cache := registry.NewCache(&stubRegistry{}, time.Hour)
+	email := registry.NewEmail(cache, "registry@example.com")
+
+	credentials, err := email.Login()
+
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 1)
+	assert.Equal(t, "registry@example.com", credentials[0].Email)
+	assert.Equal(t, "pass-1", credentials[0].Password)
+
+	// The cached Credentials are left untouched
+	cached, err := cache.Login()
+
+	assert.NoError(t, err)
+	assert.Empty(t, cached[0].Email)
+}
+
+func TestEmailLoginFailure(t *testing.T) {
+	t.Parallel()
+
+	email := registry.NewEmail(&stubRegistry{err: fmt.Errorf("failed")}, "registry@example.com")
+
+	_, err := email.Login()
+
+	assert.Error(t, err)
+}
+
+func TestUnwrap(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{}
+
+	assert.Same(t, stub, registry.Unwrap(stub))
+	assert.Same(t, stub, registry.Unwrap(registry.NewCache(stub, time.Hour)))
+	assert.Same(t, stub, registry.Unwrap(registry.NewEmail(registry.NewCache(stub, time.Hour), "registry@example.com")))
+}
@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// FileName contains a unique name.
+	FileName = "file"
+
+	// FileDebounce groups the burst of events of a single change, eg: Kubernetes swapping a whole Secret volume.
+	FileDebounce = time.Second
+)
+
+// FileConfig holds the paths of the files containing the credentials, either a whole docker config or separate
+// username, password and endpoint files.
+type FileConfig struct {
+	DockerConfigFile string `mapstructure:"docker-config-file"`
+	UsernameFile     string `mapstructure:"username-file"`
+	PasswordFile     string `mapstructure:"password-file"`
+	EndpointFile     string `mapstructure:"endpoint-file"`
+	// Endpoint is used when no endpoint file is given.
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// File represents registry credentials mounted as files, eg: by the Secrets Store CSI driver or the Vault agent.
+// The files are read on every login and watched, so that changes are picked up without a restart.
+type File struct {
+	config FileConfig
+	events chan event.GenericEvent
+}
+
+// NewFile returns a pointer to File.
+func NewFile(config FileConfig) (*File, error) {
+	if config.DockerConfigFile == "" {
+		if config.UsernameFile == "" || config.PasswordFile == "" {
+			return nil, fmt.Errorf("either the docker config file or the username and password files are mandatory")
+		}
+
+		if config.EndpointFile == "" && config.Endpoint == "" {
+			return nil, fmt.Errorf("either the endpoint file or the endpoint is mandatory")
+		}
+	}
+
+	return &File{
+		config: config,
+		events: make(chan event.GenericEvent),
+	}, nil
+}
+
+// Login reads the credentials from the files.
+func (f *File) Login() ([]*Credentials, error) {
+	if f.config.DockerConfigFile != "" {
+		data, err := os.ReadFile(f.config.DockerConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the docker config file: %w", err)
+		}
+
+		return parseDockerConfig(data)
+	}
+
+	username, err := readFile(f.config.UsernameFile)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := readFile(f.config.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := f.config.Endpoint
+	if f.config.EndpointFile != "" {
+		endpoint, err = readFile(f.config.EndpointFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []*Credentials{NewCredentials(username, password, endpoint)}, nil
+}
+
+// Events returns the channel notified whenever one of the files changed.
+func (f *File) Events() <-chan event.GenericEvent {
+	return f.events
+}
+
+// Start watches the files until the context is done. The directories are watched rather than the files, as mounted
+// volumes replace their files through a symlink swap.
+func (f *File) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create the file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	directories := map[string]bool{}
+
+	for _, path := range f.paths() {
+		directory := filepath.Dir(path)
+		if directories[directory] {
+			continue
+		}
+
+		err = watcher.Add(directory)
+		if err != nil {
+			return fmt.Errorf("failed to watch [%s]: %w", directory, err)
+		}
+
+		directories[directory] = true
+	}
+
+	// The Secrets are refreshed once on start, as the files may have changed while we weren't watching them
+	debounce := time.NewTimer(FileDebounce)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case fileEvent, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if f.isRelevant(fileEvent.Name) {
+				log.Debugf("Credentials file [%s] changed: %s", fileEvent.Name, fileEvent.Op)
+				debounce.Reset(FileDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			log.Warnf("Failed to watch the credentials files: %v", err)
+		case <-debounce.C:
+			log.Infof("Credentials files changed, refreshing the Secrets")
+
+			select {
+			case f.events <- event.GenericEvent{Object: &metav1.PartialObjectMetadata{
+				ObjectMeta: metav1.ObjectMeta{Name: FileName},
+			}}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Returns whether the changed path is one of the files, or the data of a mounted volume (eg: "..data").
+func (f *File) isRelevant(changed string) bool {
+	for _, path := range f.paths() {
+		if filepath.Dir(path) != filepath.Dir(changed) {
+			continue
+		}
+
+		if changed == path || strings.HasPrefix(filepath.Base(changed), "..") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *File) paths() []string {
+	var paths []string
+
+	for _, path := range []string{f.config.DockerConfigFile, f.config.UsernameFile, f.config.PasswordFile, f.config.EndpointFile} {
+		if path != "" {
+			paths = append(paths, filepath.Clean(path))
+		}
+	}
+
+	return paths
+}
+
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read [%s]: %w", path, err)
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("the file [%s] is empty", path)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,140 @@
+package registry_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"registry-secret-manager/pkg/registry"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLogin(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "username"), []byte("user\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "password"), []byte("pass\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, ".dockerconfigjson"), []byte(`{"auths":{"quay.io":{"auth":"cm9ib3Q6dG9rZW4="}}}`), 0o600))
+
+	tests := map[string]struct {
+		config   registry.FileConfig
+		expected []*registry.Credentials
+	}{
+		"separate files": {
+			config: registry.FileConfig{
+				UsernameFile: filepath.Join(directory, "username"),
+				PasswordFile: filepath.Join(directory, "password"),
+				Endpoint:     "https://foo.bar",
+			},
+			expected: []*registry.Credentials{registry.NewCredentials("user", "pass", "https://foo.bar")},
+		},
+		"docker config": {
+			config: registry.FileConfig{
+				DockerConfigFile: filepath.Join(directory, ".dockerconfigjson"),
+			},
+			expected: []*registry.Credentials{registry.NewCredentials("robot", "token", "quay.io")},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			file, err := registry.NewFile(test.config)
+			assert.NoError(t, err)
+
+			credentials, err := file.Login()
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, credentials)
+		})
+	}
+}
+
+func TestFileLoginMissing(t *testing.T) {
+	t.Parallel()
+
+	file, err := registry.NewFile(registry.FileConfig{
+		UsernameFile: "/does/not/exist",
+		PasswordFile: "/does/not/exist",
+		Endpoint:     "https://foo.bar",
+	})
+	assert.NoError(t, err)
+
+	_, err = file.Login()
+
+	assert.Error(t, err)
+}
+
+func TestFileInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, config := range []registry.FileConfig{
+		{},
+		{UsernameFile: "username"},
+		{UsernameFile: "username", PasswordFile: "password"},
+	} {
+		_, err := registry.NewFile(config)
+
+		assert.Error(t, err)
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	passwordFile := filepath.Join(directory, "password")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "username"), []byte("user"), 0o600))
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("old"), 0o600))
+
+	file, err := registry.NewFile(registry.FileConfig{
+		UsernameFile: filepath.Join(directory, "username"),
+		PasswordFile: passwordFile,
+		Endpoint:     "https://foo.bar",
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		assert.NoError(t, file.Start(ctx))
+	}()
+
+	// The Secrets are refreshed on start
+	select {
+	case <-file.Events():
+	case <-time.After(5 * registry.FileDebounce):
+		assert.Fail(t, "no event on start")
+	}
+
+	// Unrelated files are ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "unrelated"), []byte("foo"), 0o600))
+
+	select {
+	case <-file.Events():
+		assert.Fail(t, "unexpected event for an unrelated file")
+	case <-time.After(2 * registry.FileDebounce):
+	}
+
+	// A change of the password triggers a refresh
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("new"), 0o600))
+
+	select {
+	case <-file.Events():
+	case <-time.After(5 * registry.FileDebounce):
+		assert.Fail(t, "no event after the password changed")
+	}
+
+	credentials, err := file.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "new", credentials[0].Password)
+}
@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// GhcrName contains a unique name.
+	GhcrName = "ghcr"
+
+	// GhcrEndpoint is the GitHub Container Registry.
+	GhcrEndpoint = "ghcr.io"
+
+	// GitHubAPIURL is the GitHub API, GitHub Enterprise uses "https://<host>/api/v3" instead.
+	GitHubAPIURL = "https://api.github.com"
+
+	// GhcrUsername is ignored by the registry, the installation token identifies the GitHub App.
+	GhcrUsername = "x-access-token"
+)
+
+// GhcrConfig holds the GitHub App used to log in on the GitHub Container Registry.
+type GhcrConfig struct {
+	AppID          string `mapstructure:"app-id"`
+	InstallationID string `mapstructure:"installation-id"`
+	PrivateKeyFile string `mapstructure:"private-key-file"`
+	BaseURL        string `mapstructure:"base-url"`
+	Endpoint       string `mapstructure:"endpoint"`
+}
+
+// Ghcr represents the GitHub Container Registry, authenticated as a GitHub App installation.
+type Ghcr struct {
+	config GhcrConfig
+	client *http.Client
+}
+
+// NewGhcr returns a pointer to Ghcr, using the public GitHub endpoints unless overridden.
+func NewGhcr(config GhcrConfig) (*Ghcr, error) {
+	err := requireSettings(
+		setting{"app id", config.AppID},
+		setting{"installation id", config.InstallationID},
+		setting{"private key file", config.PrivateKeyFile},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = GitHubAPIURL
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = GhcrEndpoint
+	}
+
+	return &Ghcr{
+		config: config,
+		client: newHTTPClient(),
+	}, nil
+}
+
+// Login exchanges a GitHub App JWT for an installation token, which expires after an hour.
+func (g *Ghcr) Login() ([]*Credentials, error) {
+	// The private key is read on every login, so a rotated key is picked up without a restart
+	privateKey, err := readRSAPrivateKey(g.config.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := signGitHubAppJWT(g.config.AppID, privateKey, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%s/access_tokens", strings.TrimSuffix(g.config.BaseURL, "/"), g.config.InstallationID)
+
+	request, err := newRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("Authorization", "Bearer "+jwt)
+
+	token := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+
+	_, err = doRequest(g.client, request, http.StatusCreated, &token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve an installation token: %w", err)
+	}
+
+	if token.Token == "" {
+		return nil, fmt.Errorf("no installation token returned")
+	}
+
+	credentials := NewCredentials(GhcrUsername, token.Token, g.config.Endpoint)
+	credentials.ExpiresAt = token.ExpiresAt
+
+	return []*Credentials{credentials}, nil
+}
+
+// Reads an RSA private key in either the PKCS#1 format (as downloaded from GitHub) or the PKCS#8 format.
+func readRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the private key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode the private key: no PEM data found")
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key: %w", err)
+	}
+
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key is not an RSA key")
+	}
+
+	return privateKey, nil
+}
+
+// Signs the JWT a GitHub App authenticates with. It is backdated a minute to allow for clock drift and is valid for
+// less than the allowed 10 minutes.
+func signGitHubAppJWT(appID string, privateKey *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall the JWT header: %w", err)
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall the JWT claims: %w", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the JWT: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
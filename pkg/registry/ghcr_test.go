@@ -0,0 +1,135 @@
+package registry_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"registry-secret-manager/pkg/registry"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a new RSA private key in the PKCS#1 format GitHub hands out.
+func writePrivateKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "private-key.pem")
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	return privateKey, path
+}
+
+// Verifies the JWT signature and returns its claims.
+func verifyJWT(t *testing.T, publicKey *rsa.PublicKey, jwt string) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.Split(jwt, ".")
+	assert.Len(t, parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+
+	claims := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(claimsJSON, &claims))
+
+	return claims
+}
+
+func TestGhcrLogin(t *testing.T) {
+	t.Parallel()
+
+	privateKey, path := writePrivateKey(t)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, http.MethodPost, request.Method)
+		assert.Equal(t, "/api/v3/app/installations/42/access_tokens", request.URL.Path)
+
+		claims := verifyJWT(t, &privateKey.PublicKey, strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer "))
+		assert.Equal(t, "1234", claims["iss"])
+
+		writer.WriteHeader(http.StatusCreated)
+		_, _ = writer.Write([]byte(`{"token":"ghs_token","expires_at":"` + expiresAt.Format(time.RFC3339) + `"}`))
+	}))
+	defer server.Close()
+
+	ghcr, err := registry.NewGhcr(registry.GhcrConfig{
+		AppID:          "1234",
+		InstallationID: "42",
+		PrivateKeyFile: path,
+		BaseURL:        server.URL + "/api/v3",
+	})
+	assert.NoError(t, err)
+
+	credentials, err := ghcr.Login()
+
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 1)
+	assert.Equal(t, "x-access-token", credentials[0].Username)
+	assert.Equal(t, "ghs_token", credentials[0].Password)
+	assert.Equal(t, "ghcr.io", credentials[0].Endpoint)
+	assert.True(t, expiresAt.Equal(credentials[0].ExpiresAt))
+}
+
+func TestGhcrLoginRejected(t *testing.T) {
+	t.Parallel()
+
+	_, path := writePrivateKey(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ghcr, err := registry.NewGhcr(registry.GhcrConfig{
+		AppID:          "1234",
+		InstallationID: "42",
+		PrivateKeyFile: path,
+		BaseURL:        server.URL,
+	})
+	assert.NoError(t, err)
+
+	_, err = ghcr.Login()
+
+	assert.Error(t, err)
+}
+
+func TestGhcrInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := registry.NewGhcr(registry.GhcrConfig{AppID: "1234"})
+
+	assert.EqualError(t, err, "the installation id and private key file are mandatory")
+
+	ghcr, err := registry.NewGhcr(registry.GhcrConfig{AppID: "1234", InstallationID: "42", PrivateKeyFile: "/does/not/exist"})
+	assert.NoError(t, err)
+
+	_, err = ghcr.Login()
+
+	assert.Error(t, err)
+}
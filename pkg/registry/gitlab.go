@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// GitLabName contains a unique name.
+	GitLabName = "gitlab"
+
+	// GitLabURL is the GitLab instance, self-managed instances use their own URL instead.
+	GitLabURL = "https://gitlab.com"
+
+	// GitLabEndpoint is the container registry of GitLab.com.
+	GitLabEndpoint = "registry.gitlab.com"
+
+	// GitLabDeployTokenPrefix identifies the deploy tokens created by us, the suffix is their creation time.
+	GitLabDeployTokenPrefix = "registry-secret-manager-"
+
+	// GitLabTokenLifetime is how long a deploy token is valid.
+	GitLabTokenLifetime = 24 * time.Hour
+
+	// GitLabGracePeriod is how long a deploy token is kept once superseded by the next one, which is created shortly
+	// before it expires. The Secrets holding it are refreshed before it expires anyway.
+	GitLabGracePeriod = 6 * time.Hour
+)
+
+// GitLabConfig holds the group whose registry is accessed through short-lived deploy tokens.
+type GitLabConfig struct {
+	BaseURL       string        `mapstructure:"base-url"`
+	GroupID       string        `mapstructure:"group-id"`
+	Endpoint      string        `mapstructure:"endpoint"`
+	TokenLifetime time.Duration `mapstructure:"token-lifetime"`
+	GracePeriod   time.Duration `mapstructure:"grace-period"`
+}
+
+// GitLab represents a GitLab Container Registry, authenticated with a group deploy token reused until it is about to
+// expire.
+type GitLab struct {
+	config GitLabConfig
+	client *http.Client
+
+	mutex     sync.Mutex
+	current   *gitLabDeployToken
+	expiresAt time.Time
+}
+
+type gitLabDeployToken struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	Revoked  bool   `json:"revoked"`
+}
+
+// NewGitLab returns a pointer to GitLab, using GitLab.com unless overridden.
+func NewGitLab(config GitLabConfig) (*GitLab, error) {
+	err := requireSettings(setting{"group id", config.GroupID})
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = GitLabURL
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = GitLabEndpoint
+	}
+
+	if config.TokenLifetime == 0 {
+		config.TokenLifetime = GitLabTokenLifetime
+	}
+
+	if config.GracePeriod == 0 {
+		config.GracePeriod = GitLabGracePeriod
+	}
+
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &GitLab{
+		config: config,
+		client: newHTTPClient(),
+	}, nil
+}
+
+// Login reuses the current deploy token, or creates a new one scoped to read_registry once it is about to expire and
+// revokes the ones superseded for longer than their grace period. Even a forced refresh reuses the current deploy
+// token, so they don't pile up.
+func (g *GitLab) Login() ([]*Credentials, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.current != nil && time.Until(g.expiresAt) > ExpiryMargin {
+		return []*Credentials{g.credentials()}, nil
+	}
+
+	accessToken, present := os.LookupEnv("GITLAB_TOKEN")
+	if !present || accessToken == "" {
+		return nil, fmt.Errorf("could not find environment value for GITLAB_TOKEN")
+	}
+
+	now := time.Now()
+
+	// Revoking is best effort, the tokens expire on their own anyway
+	err := g.revokeDeployTokens(accessToken, now)
+	if err != nil {
+		log.Warnf("Failed to revoke the old GitLab deploy tokens of group [%s]: %v", g.config.GroupID, err)
+	}
+
+	expiresAt := now.Add(g.config.TokenLifetime).UTC()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"name":       GitLabDeployTokenPrefix + strconv.FormatInt(now.Unix(), 10),
+		"scopes":     []string{"read_registry"},
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshall the deploy token request: %w", err)
+	}
+
+	deployToken := &gitLabDeployToken{}
+
+	_, err = g.request(http.MethodPost, g.deployTokensURL(), accessToken, bytes.NewReader(body), http.StatusCreated, deployToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a deploy token: %w", err)
+	}
+
+	g.current = deployToken
+	g.expiresAt = expiresAt
+
+	return []*Credentials{g.credentials()}, nil
+}
+
+// Returns new Credentials of the current deploy token, as the callers may modify them.
+func (g *GitLab) credentials() *Credentials {
+	credentials := NewCredentials(g.current.Username, g.current.Token, g.config.Endpoint)
+	credentials.ExpiresAt = g.expiresAt
+
+	return credentials
+}
+
+// Revokes our deploy tokens superseded for longer than the grace period, which are superseded shortly before they
+// expire. Their creation time is part of their name, so tokens created by other replicas or before a restart are
+// revoked as well.
+func (g *GitLab) revokeDeployTokens(accessToken string, now time.Time) error {
+	for page := "1"; page != ""; {
+		var deployTokens []gitLabDeployToken
+
+		header, err := g.request(http.MethodGet, g.deployTokensURL()+"?per_page=100&page="+page, accessToken, nil, http.StatusOK, &deployTokens)
+		if err != nil {
+			return fmt.Errorf("failed to list the deploy tokens: %w", err)
+		}
+
+		for _, deployToken := range deployTokens {
+			if deployToken.Revoked || !strings.HasPrefix(deployToken.Name, GitLabDeployTokenPrefix) {
+				continue
+			}
+
+			created, err := strconv.ParseInt(strings.TrimPrefix(deployToken.Name, GitLabDeployTokenPrefix), 10, 64)
+			supersededAt := time.Unix(created, 0).Add(g.config.TokenLifetime - ExpiryMargin)
+			if err != nil || now.Sub(supersededAt) < g.config.GracePeriod {
+				continue
+			}
+
+			url := fmt.Sprintf("%s/%d", g.deployTokensURL(), deployToken.ID)
+
+			_, err = g.request(http.MethodDelete, url, accessToken, nil, http.StatusNoContent, nil)
+			if err != nil {
+				return fmt.Errorf("failed to revoke deploy token [%s]: %w", deployToken.Name, err)
+			}
+
+			log.Infof("Revoked GitLab deploy token [%s] of group [%s]", deployToken.Name, g.config.GroupID)
+		}
+
+		page = header.Get("X-Next-Page")
+	}
+
+	return nil
+}
+
+func (g *GitLab) deployTokensURL() string {
+	return fmt.Sprintf("%s/api/v4/groups/%s/deploy_tokens", g.config.BaseURL, g.config.GroupID)
+}
+
+// Performs a request on the GitLab API, decoding the response into result when given.
+func (g *GitLab) request(method, url, accessToken string, body io.Reader, status int, result interface{}) (http.Header, error) {
+	request, err := newRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("PRIVATE-TOKEN", accessToken)
+
+	return doRequest(g.client, request, status, result)
+}
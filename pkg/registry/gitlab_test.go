@@ -0,0 +1,207 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"registry-secret-manager/pkg/registry"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeGitLabDeployToken struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Token    string `json:"token,omitempty"`
+	Revoked  bool   `json:"revoked"`
+}
+
+// Serves a stand-in for the deploy tokens API of a GitLab group.
+type fakeGitLab struct {
+	mutex  sync.Mutex
+	tokens []*fakeGitLabDeployToken
+	nextID int
+}
+
+func (f *fakeGitLab) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if request.Header.Get("PRIVATE-TOKEN") != "glpat-token" {
+		writer.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	path := strings.TrimPrefix(request.URL.Path, "/api/v4/groups/42/deploy_tokens")
+
+	switch {
+	case request.Method == http.MethodGet && path == "":
+		_ = json.NewEncoder(writer).Encode(f.tokens)
+	case request.Method == http.MethodPost && path == "":
+		body := struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}{}
+		_ = json.NewDecoder(request.Body).Decode(&body)
+
+		if len(body.Scopes) != 1 || body.Scopes[0] != "read_registry" {
+			writer.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		f.nextID++
+		token := &fakeGitLabDeployToken{
+			ID:       f.nextID,
+			Name:     body.Name,
+			Username: fmt.Sprintf("gitlab+deploy-token-%d", f.nextID),
+			Token:    fmt.Sprintf("token-%d", f.nextID),
+		}
+		f.tokens = append(f.tokens, token)
+
+		writer.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(writer).Encode(token)
+	case request.Method == http.MethodDelete:
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "/"))
+
+		for i, token := range f.tokens {
+			if token.ID == id {
+				f.tokens = append(f.tokens[:i], f.tokens[i+1:]...)
+				writer.WriteHeader(http.StatusNoContent)
+
+				return
+			}
+		}
+
+		writer.WriteHeader(http.StatusNotFound)
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGitLab) names() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var names []string
+	for _, token := range f.tokens {
+		names = append(names, token.Name)
+	}
+
+	return names
+}
+
+//nolint:paralleltest // Uses environment variables for the access token
+func TestGitLabLogin(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "glpat-token")
+
+	// Superseded 7 hours ago, and 10 minutes ago
+	expired := registry.GitLabDeployTokenPrefix + strconv.FormatInt(time.Now().Add(-registry.GitLabTokenLifetime-7*time.Hour).Unix(), 10)
+	recent := registry.GitLabDeployTokenPrefix + strconv.FormatInt(time.Now().Add(-registry.GitLabTokenLifetime).Unix(), 10)
+
+	fake := &fakeGitLab{
+		tokens: []*fakeGitLabDeployToken{
+			{ID: 1, Name: "created-by-someone-else"},
+			{ID: 2, Name: expired},
+			{ID: 3, Name: recent},
+		},
+		nextID: 3,
+	}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	gitLab, err := registry.NewGitLab(registry.GitLabConfig{
+		BaseURL: server.URL,
+		GroupID: "42",
+	})
+	assert.NoError(t, err)
+
+	credentials, err := gitLab.Login()
+
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 1)
+	assert.Equal(t, "gitlab+deploy-token-4", credentials[0].Username)
+	assert.Equal(t, "token-4", credentials[0].Password)
+	assert.Equal(t, "registry.gitlab.com", credentials[0].Endpoint)
+	assert.WithinDuration(t, time.Now().Add(registry.GitLabTokenLifetime), credentials[0].ExpiresAt, time.Minute)
+
+	// Only our token superseded for longer than the grace period got revoked
+	names := fake.names()
+
+	assert.Len(t, names, 3)
+	assert.Equal(t, []string{"created-by-someone-else", recent}, names[:2])
+	assert.True(t, strings.HasPrefix(names[2], registry.GitLabDeployTokenPrefix))
+
+	// The deploy token is reused until it is about to expire, even when a refresh is forced
+	cache := registry.NewCache(gitLab, time.Hour)
+	cache.Invalidate()
+
+	reused, err := cache.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, credentials, reused)
+	assert.Len(t, fake.names(), 3)
+}
+
+//nolint:paralleltest // Uses environment variables for the access token
+func TestGitLabLoginExpiringToken(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "glpat-token")
+
+	fake := &fakeGitLab{}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	gitLab, err := registry.NewGitLab(registry.GitLabConfig{
+		BaseURL:       server.URL,
+		GroupID:       "42",
+		TokenLifetime: registry.ExpiryMargin,
+	})
+	assert.NoError(t, err)
+
+	first, err := gitLab.Login()
+	assert.NoError(t, err)
+
+	// The deploy token is about to expire right away, so the next login creates another one
+	second, err := gitLab.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", first[0].Password)
+	assert.Equal(t, "token-2", second[0].Password)
+	assert.Len(t, fake.names(), 2)
+}
+
+//nolint:paralleltest // Uses environment variables for the access token
+func TestGitLabLoginRejected(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "revoked")
+
+	server := httptest.NewServer(&fakeGitLab{})
+	defer server.Close()
+
+	gitLab, err := registry.NewGitLab(registry.GitLabConfig{
+		BaseURL: server.URL,
+		GroupID: "42",
+	})
+	assert.NoError(t, err)
+
+	_, err = gitLab.Login()
+
+	assert.Error(t, err)
+}
+
+func TestGitLabInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := registry.NewGitLab(registry.GitLabConfig{})
+
+	assert.EqualError(t, err, "the group id is mandatory")
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPTimeout bounds the requests on the registries and their APIs.
+const HTTPTimeout = 10 * time.Second
+
+// Rejection of a request, with the response telling why.
+type statusError struct {
+	status int
+	header http.Header
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("request rejected with status %d", e.status)
+}
+
+// A mandatory setting of a registry, named as in the error messages.
+type setting struct {
+	name  string
+	value string
+}
+
+// Returns the HTTP client of the registries.
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: HTTPTimeout}
+}
+
+// Creates a request, whose headers are set by the caller.
+func newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the request: %w", err)
+	}
+
+	return request, nil
+}
+
+// Performs the request and returns the header of the response, which is decoded into result when given. Any other
+// status than the expected one is a *statusError.
+func doRequest(client *http.Client, request *http.Request, status int, result interface{}) (http.Header, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform the request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != status {
+		return nil, &statusError{status: response.StatusCode, header: response.Header}
+	}
+
+	if result != nil {
+		err = json.NewDecoder(response.Body).Decode(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the response: %w", err)
+		}
+	}
+
+	return response.Header, nil
+}
+
+// Returns an error naming the mandatory settings which are empty.
+func requireSettings(settings ...setting) error {
+	var missing []string
+
+	for _, required := range settings {
+		if required.value == "" {
+			missing = append(missing, required.name)
+		}
+	}
+
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("the %s is mandatory", missing[0])
+	default:
+		last := len(missing) - 1
+
+		return fmt.Errorf("the %s and %s are mandatory", strings.Join(missing[:last], ", "), missing[last])
+	}
+}
@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KubernetesSecretName contains a unique name.
+	KubernetesSecretName = "kubernetes-secret"
+
+	// KubernetesSecretNamespace is the namespace of the manager, where the source Secrets live.
+	KubernetesSecretNamespace = "registry-secret-manager"
+
+	// KubernetesSecretEndpointAnnotation holds the registry endpoint of a basic-auth source Secret.
+	KubernetesSecretEndpointAnnotation = "registry-secret-manager/endpoint"
+)
+
+// KubernetesSecretConfig holds the names of the source Secrets in the namespace of the manager.
+type KubernetesSecretConfig struct {
+	Namespace string   `mapstructure:"namespace"`
+	Names     []string `mapstructure:"names"`
+}
+
+// KubernetesSecret represents registry credentials stored in existing dockerconfigjson or basic-auth Secrets. The
+// Secrets are read through the client of the manager, which needs to be injected first.
+type KubernetesSecret struct {
+	config KubernetesSecretConfig
+	client client.Reader
+}
+
+// Docker config as found in existing Secrets and files, only the fields needed to log in.
+type sourceDockerConfig struct {
+	Authorizations map[string]struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	} `json:"auths"`
+}
+
+// NewKubernetesSecret returns a pointer to KubernetesSecret, reading from the namespace of the manager unless overridden.
+func NewKubernetesSecret(config KubernetesSecretConfig) (*KubernetesSecret, error) {
+	if len(config.Names) < 1 {
+		return nil, fmt.Errorf("at least one Secret name must be defined")
+	}
+
+	if config.Namespace == "" {
+		config.Namespace = KubernetesSecretNamespace
+	}
+
+	return &KubernetesSecret{
+		config: config,
+	}, nil
+}
+
+// InjectClient is called by the manager to provide its client.
+func (k *KubernetesSecret) InjectClient(client client.Client) error {
+	k.client = client
+
+	return nil
+}
+
+// IsSource returns whether the given object is one of the source Secrets.
+func (k *KubernetesSecret) IsSource(object client.Object) bool {
+	if object.GetNamespace() != k.config.Namespace {
+		return false
+	}
+
+	for _, name := range k.config.Names {
+		if object.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Login reads the credentials from the source Secrets.
+func (k *KubernetesSecret) Login() ([]*Credentials, error) {
+	if k.client == nil {
+		return nil, fmt.Errorf("no client has been injected")
+	}
+
+	var credentials []*Credentials
+
+	for _, name := range k.config.Names {
+		secretName := types.NamespacedName{
+			Namespace: k.config.Namespace,
+			Name:      name,
+		}
+		secret := &corev1.Secret{}
+
+		err := k.client.Get(context.Background(), secretName, secret)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch the source Secret [%s]: %w", secretName, err)
+		}
+
+		secretCredentials, err := parseSourceSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source Secret [%s]: %w", secretName, err)
+		}
+
+		credentials = append(credentials, secretCredentials...)
+	}
+
+	return credentials, nil
+}
+
+func parseSourceSecret(secret *corev1.Secret) ([]*Credentials, error) {
+	switch secret.Type {
+	case corev1.SecretTypeBasicAuth:
+		username := string(secret.Data[corev1.BasicAuthUsernameKey])
+		password := string(secret.Data[corev1.BasicAuthPasswordKey])
+		endpoint := secret.Annotations[KubernetesSecretEndpointAnnotation]
+
+		if username == "" || password == "" || endpoint == "" {
+			return nil, fmt.Errorf("a username, password and the %s annotation are required", KubernetesSecretEndpointAnnotation)
+		}
+
+		return []*Credentials{NewCredentials(username, password, endpoint)}, nil
+	case corev1.SecretTypeDockerConfigJson:
+		return parseDockerConfig(secret.Data[corev1.DockerConfigJsonKey])
+	default:
+		return nil, fmt.Errorf("unsupported Secret type %s", secret.Type)
+	}
+}
+
+// Returns the credentials of every endpoint of a docker config, sorted by endpoint.
+func parseDockerConfig(data []byte) ([]*Credentials, error) {
+	dockerConfig := &sourceDockerConfig{}
+
+	err := json.Unmarshal(data, dockerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the docker config: %w", err)
+	}
+
+	var endpoints []string
+	for endpoint := range dockerConfig.Authorizations {
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Strings(endpoints)
+
+	var credentials []*Credentials
+
+	for _, endpoint := range endpoints {
+		authorization := dockerConfig.Authorizations[endpoint]
+		username, password := authorization.Username, authorization.Password
+
+		// The username and password are optional when the auth field is set
+		if username == "" || password == "" {
+			auth, err := base64.StdEncoding.DecodeString(authorization.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the auth of [%s]: %w", endpoint, err)
+			}
+
+			parts := strings.SplitN(string(auth), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth of [%s]", endpoint)
+			}
+
+			username, password = parts[0], parts[1]
+		}
+
+		credentials = append(credentials, NewCredentials(username, password, endpoint))
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,143 @@
+package registry_test
+
+import (
+	"registry-secret-manager/pkg/registry"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestKubernetesSecretLogin(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "registry-secret-manager",
+				Name:      "docker-config",
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{` +
+					`"quay.io":{"auth":"cm9ib3Q6dG9rZW4="},` +
+					`"https://foo.bar":{"username":"user","password":"pass"}}}`),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "registry-secret-manager",
+				Name:      "basic-auth",
+				Annotations: map[string]string{
+					registry.KubernetesSecretEndpointAnnotation: "harbor.example.com",
+				},
+			},
+			Type: corev1.SecretTypeBasicAuth,
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("harbor"),
+				corev1.BasicAuthPasswordKey: []byte("secret"),
+			},
+		},
+	).Build()
+
+	kubernetesSecret, err := registry.NewKubernetesSecret(registry.KubernetesSecretConfig{
+		Names: []string{"docker-config", "basic-auth"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, kubernetesSecret.InjectClient(fakeClient))
+
+	credentials, err := kubernetesSecret.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{
+		registry.NewCredentials("user", "pass", "https://foo.bar"),
+		registry.NewCredentials("robot", "token", "quay.io"),
+		registry.NewCredentials("harbor", "secret", "harbor.example.com"),
+	}, credentials)
+}
+
+func TestKubernetesSecretLoginInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]*corev1.Secret{
+		"missing": nil,
+		"opaque": {
+			Type: corev1.SecretTypeOpaque,
+		},
+		"basic auth without endpoint": {
+			Type: corev1.SecretTypeBasicAuth,
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("harbor"),
+				corev1.BasicAuthPasswordKey: []byte("secret"),
+			},
+		},
+		"invalid auth": {
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"bm8tY29sb24="}}}`),
+			},
+		},
+	}
+
+	for name, secret := range tests {
+		secret := secret
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClientBuilder := fake.NewClientBuilder()
+			if secret != nil {
+				secret.Namespace = "registry-secret-manager"
+				secret.Name = "source"
+				fakeClientBuilder.WithObjects(secret)
+			}
+
+			kubernetesSecret, err := registry.NewKubernetesSecret(registry.KubernetesSecretConfig{
+				Names: []string{"source"},
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, kubernetesSecret.InjectClient(fakeClientBuilder.Build()))
+
+			_, err = kubernetesSecret.Login()
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKubernetesSecretIsSource(t *testing.T) {
+	t.Parallel()
+
+	kubernetesSecret, err := registry.NewKubernetesSecret(registry.KubernetesSecretConfig{
+		Names: []string{"source"},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, kubernetesSecret.IsSource(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "registry-secret-manager", Name: "source"},
+	}))
+	assert.False(t, kubernetesSecret.IsSource(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "source"},
+	}))
+	assert.False(t, kubernetesSecret.IsSource(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "registry-secret-manager", Name: "registry-secret"},
+	}))
+}
+
+func TestKubernetesSecretWithoutClient(t *testing.T) {
+	t.Parallel()
+
+	_, err := registry.NewKubernetesSecret(registry.KubernetesSecretConfig{})
+
+	assert.Error(t, err)
+
+	kubernetesSecret, err := registry.NewKubernetesSecret(registry.KubernetesSecretConfig{Names: []string{"source"}})
+	assert.NoError(t, err)
+
+	_, err = kubernetesSecret.Login()
+
+	assert.Error(t, err)
+}
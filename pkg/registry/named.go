@@ -0,0 +1,46 @@
+package registry
+
+// Named wraps a Registry with the name it is configured by, eg: "ecr".
+type Named struct {
+	registry Registry
+	name     string
+}
+
+// NewNamed returns a pointer to Named.
+func NewNamed(registry Registry, name string) *Named {
+	return &Named{
+		registry: registry,
+		name:     name,
+	}
+}
+
+// Login returns the Credentials of the wrapped Registry.
+func (n *Named) Login() ([]*Credentials, error) {
+	return n.registry.Login()
+}
+
+// Name returns the name of the Registry.
+func (n *Named) Name() string {
+	return n.name
+}
+
+// Unwrap returns the wrapped Registry.
+func (n *Named) Unwrap() Registry {
+	return n.registry
+}
+
+// NameOf returns the name of a Registry wrapped by Named, or an empty string when it isn't.
+func NameOf(registry Registry) string {
+	name := ""
+
+	walk(registry, func(registry Registry) bool {
+		named, ok := registry.(*Named)
+		if ok {
+			name = named.Name()
+		}
+
+		return !ok
+	})
+
+	return name
+}
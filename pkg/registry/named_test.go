@@ -0,0 +1,20 @@
+package registry_test
+
+import (
+	"registry-secret-manager/pkg/registry"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameOf(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{}
+	named := registry.NewNamed(registry.NewEmail(registry.NewCache(stub, time.Hour), "registry@example.com"), "ecr")
+
+	assert.Equal(t, "ecr", registry.NameOf(named))
+	assert.Equal(t, "", registry.NameOf(stub))
+	assert.Same(t, stub, registry.Unwrap(named))
+}
@@ -1,6 +1,58 @@
 package registry
 
-// Registry represents a container registry.
+import "time"
+
+// Registry represents a container registry, which may be reachable through several endpoints (eg: ECR accounts).
 type Registry interface {
-	Login() (*Credentials, error)
+	Login() ([]*Credentials, error)
+}
+
+// CredentialsEnvironment lists the environment variables the registries read their credentials from, the Secrets are
+// rendered again when any of them changed.
+var CredentialsEnvironment = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_PROFILE",
+	"AWS_ROLE_ARN",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"DOCKER_HUB_ENDPOINT",
+	"DOCKER_HUB_PASSWORD",
+	"DOCKER_HUB_USERNAME",
+	"GITLAB_TOKEN",
+	"TOKEN_SERVICE_PASSWORD",
+	"TOKEN_SERVICE_USERNAME",
+	"VAULT_ADDR",
+	"VAULT_TOKEN",
+}
+
+// walk visits the Registry and every Registry it wraps, outermost first, until visit returns false. It returns the
+// last visited Registry.
+func walk(registry Registry, visit func(Registry) bool) Registry {
+	for visit(registry) {
+		wrapper, ok := registry.(interface{ Unwrap() Registry })
+		if !ok {
+			break
+		}
+
+		registry = wrapper.Unwrap()
+	}
+
+	return registry
+}
+
+// Unwrap returns the Registry wrapped by a Cache, Email or Named, as is when it isn't wrapped.
+func Unwrap(registry Registry) Registry {
+	return walk(registry, func(Registry) bool { return true })
+}
+
+// InvalidateBefore invalidates the cached Credentials of a Registry wrapped by a Cache when they were obtained before
+// the given time, forcing a new login on the next call.
+func InvalidateBefore(registry Registry, before time.Time) {
+	walk(registry, func(registry Registry) bool {
+		if cache, ok := registry.(*Cache); ok {
+			cache.InvalidateBefore(before)
+		}
+
+		return true
+	})
 }
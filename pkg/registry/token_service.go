@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// TokenServiceName contains a unique name.
+	TokenServiceName = "token-service"
+
+	// TokenServiceClientID identifies us on the token service.
+	TokenServiceClientID = "registry-secret-manager"
+)
+
+// TokenServiceConfig holds the registry using the Docker registry token protocol, eg: Harbor, Quay or Artifactory.
+type TokenServiceConfig struct {
+	// URL of the registry, eg: https://harbor.example.com
+	URL string `mapstructure:"url"`
+	// Endpoint defaults to the host of the URL.
+	Endpoint string `mapstructure:"endpoint"`
+	ClientID string `mapstructure:"client-id"`
+}
+
+// TokenService represents a registry handing out bearer tokens, logging in for a refresh token which is stored as the
+// identity token. The username and password are kept as well, as the kubelet ignores identity tokens.
+type TokenService struct {
+	config TokenServiceConfig
+	client *http.Client
+}
+
+type tokenServiceResponse struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// NewTokenService returns a pointer to TokenService.
+func NewTokenService(config TokenServiceConfig) (*TokenService, error) {
+	registryURL, err := url.Parse(config.URL)
+	if err != nil || registryURL.Host == "" {
+		return nil, fmt.Errorf("invalid registry URL [%s]", config.URL)
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = registryURL.Host
+	}
+
+	if config.ClientID == "" {
+		config.ClientID = TokenServiceClientID
+	}
+
+	config.URL = strings.TrimSuffix(config.URL, "/")
+
+	return &TokenService{
+		config: config,
+		client: newHTTPClient(),
+	}, nil
+}
+
+// Login follows the challenge of the registry to its token service, and asks it for a refresh token.
+func (t *TokenService) Login() ([]*Credentials, error) {
+	username, present := os.LookupEnv("TOKEN_SERVICE_USERNAME")
+	if !present || username == "" {
+		return nil, fmt.Errorf("could not find environment value for TOKEN_SERVICE_USERNAME")
+	}
+
+	password, present := os.LookupEnv("TOKEN_SERVICE_PASSWORD")
+	if !present || password == "" {
+		return nil, fmt.Errorf("could not find environment value for TOKEN_SERVICE_PASSWORD")
+	}
+
+	realm, service, err := t.challenge()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the challenge of [%s]: %w", t.config.URL, err)
+	}
+
+	refreshToken, err := t.fetchRefreshToken(realm, service, username, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve a refresh token from [%s]: %w", realm, err)
+	}
+
+	credentials := NewCredentials(username, password, t.config.Endpoint)
+	credentials.IdentityToken = refreshToken
+
+	return []*Credentials{credentials}, nil
+}
+
+// Requests the API version check anonymously, to be challenged with the realm and service of the token service.
+func (t *TokenService) challenge() (string, string, error) {
+	request, err := newRequest(http.MethodGet, t.config.URL+"/v2/", nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	header, err := doRequest(t.client, request, http.StatusUnauthorized, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	scheme, params := parseChallenge(header.Get("WWW-Authenticate"))
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return "", "", fmt.Errorf("no bearer challenge: %s", header.Get("WWW-Authenticate"))
+	}
+
+	return params["realm"], params["service"], nil
+}
+
+// Requests an offline token with the OAuth2 password grant, falling back on basic authentication for token services
+// which don't support OAuth2.
+func (t *TokenService) fetchRefreshToken(realm, service, username, password string) (string, error) {
+	form := url.Values{
+		"grant_type":  {"password"},
+		"access_type": {"offline"},
+		"client_id":   {t.config.ClientID},
+		"service":     {service},
+		"username":    {username},
+		"password":    {password},
+	}
+
+	request, err := newRequest(http.MethodPost, realm, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token := &tokenServiceResponse{}
+
+	_, err = doRequest(t.client, request, http.StatusOK, token)
+
+	var rejected *statusError
+	if errors.As(err, &rejected) && (rejected.status == http.StatusNotFound || rejected.status == http.StatusMethodNotAllowed) {
+		err = t.fetchOfflineToken(realm, service, username, password, token)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if token.RefreshToken == "" {
+		return "", fmt.Errorf("no refresh token returned")
+	}
+
+	return token.RefreshToken, nil
+}
+
+func (t *TokenService) fetchOfflineToken(realm, service, username, password string, token *tokenServiceResponse) error {
+	query := url.Values{
+		"offline_token": {"true"},
+		"client_id":     {t.config.ClientID},
+		"service":       {service},
+	}
+
+	request, err := newRequest(http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(username, password)
+
+	_, err = doRequest(t.client, request, http.StatusOK, token)
+
+	return err
+}
+
+// Parses a WWW-Authenticate header, eg: Bearer realm="https://auth.example.com/token",service="registry".
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+
+			params[key], rest = value[1:end+1], value[end+2:]
+
+			continue
+		}
+
+		value, rest, _ = strings.Cut(value, ",")
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return scheme, params
+}
@@ -0,0 +1,129 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"registry-secret-manager/pkg/registry"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a stand-in for a registry challenging to its token service, which supports OAuth2 or only basic auth.
+func newFakeTokenServiceServer(t *testing.T, oauth bool) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/service/token",service="harbor-registry"`)
+		writer.WriteHeader(http.StatusUnauthorized)
+	})
+	mux.HandleFunc("/service/token", func(writer http.ResponseWriter, request *http.Request) {
+		var username, password string
+
+		switch {
+		case request.Method == http.MethodPost && oauth:
+			assert.NoError(t, request.ParseForm())
+			assert.Equal(t, "password", request.PostForm.Get("grant_type"))
+			assert.Equal(t, "offline", request.PostForm.Get("access_type"))
+			assert.Equal(t, "harbor-registry", request.PostForm.Get("service"))
+
+			username, password = request.PostForm.Get("username"), request.PostForm.Get("password")
+		case request.Method == http.MethodGet && !oauth:
+			assert.Equal(t, "true", request.URL.Query().Get("offline_token"))
+			assert.Equal(t, "harbor-registry", request.URL.Query().Get("service"))
+
+			username, password, _ = request.BasicAuth()
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		if username != "robot$ci" || password != "secret" {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		_ = json.NewEncoder(writer).Encode(map[string]string{
+			"access_token":  "access",
+			"refresh_token": "refresh",
+		})
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestTokenServiceLogin(t *testing.T) {
+	t.Setenv("TOKEN_SERVICE_USERNAME", "robot$ci")
+	t.Setenv("TOKEN_SERVICE_PASSWORD", "secret")
+
+	for name, oauth := range map[string]bool{"oauth2": true, "basic auth": false} {
+		t.Run(name, func(t *testing.T) {
+			server := newFakeTokenServiceServer(t, oauth)
+
+			tokenService, err := registry.NewTokenService(registry.TokenServiceConfig{URL: server.URL})
+			assert.NoError(t, err)
+
+			credentials, err := tokenService.Login()
+
+			assert.NoError(t, err)
+			assert.Len(t, credentials, 1)
+			assert.Equal(t, "robot$ci", credentials[0].Username)
+			assert.Equal(t, "secret", credentials[0].Password)
+			assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), credentials[0].Endpoint)
+			assert.Equal(t, "refresh", credentials[0].IdentityToken)
+		})
+	}
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestTokenServiceLoginRejected(t *testing.T) {
+	t.Setenv("TOKEN_SERVICE_USERNAME", "robot$ci")
+	t.Setenv("TOKEN_SERVICE_PASSWORD", "wrong")
+
+	server := newFakeTokenServiceServer(t, true)
+
+	tokenService, err := registry.NewTokenService(registry.TokenServiceConfig{URL: server.URL, Endpoint: "harbor.example.com"})
+	assert.NoError(t, err)
+
+	_, err = tokenService.Login()
+
+	assert.Error(t, err)
+}
+
+//nolint:paralleltest // Uses environment variables for the credentials
+func TestTokenServiceLoginWithoutChallenge(t *testing.T) {
+	t.Setenv("TOKEN_SERVICE_USERNAME", "robot$ci")
+	t.Setenv("TOKEN_SERVICE_PASSWORD", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		writer.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	tokenService, err := registry.NewTokenService(registry.TokenServiceConfig{URL: server.URL})
+	assert.NoError(t, err)
+
+	_, err = tokenService.Login()
+
+	assert.Error(t, err)
+}
+
+func TestTokenServiceInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := registry.NewTokenService(registry.TokenServiceConfig{URL: "harbor.example.com"})
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// VaultName contains a unique name.
+	VaultName = "vault"
+
+	// VaultAuthKubernetes logs in with the ServiceAccount token of the manager.
+	VaultAuthKubernetes = "kubernetes"
+
+	// VaultAuthToken logs in with the VAULT_TOKEN environment variable.
+	VaultAuthToken = "token"
+
+	// ServiceAccountTokenFile is where Kubernetes mounts the ServiceAccount token.
+	ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// VaultConfig holds the Vault path containing the registry credentials and how to authenticate on Vault.
+type VaultConfig struct {
+	// Address defaults to the VAULT_ADDR environment variable.
+	Address string `mapstructure:"address"`
+	// Path is the API path of the secret, eg: "secret/data/registry" for the KV v2 engine mounted on "secret".
+	Path string `mapstructure:"path"`
+
+	AuthMethod              string `mapstructure:"auth-method"`
+	AuthMount               string `mapstructure:"auth-mount"`
+	Role                    string `mapstructure:"role"`
+	ServiceAccountTokenFile string `mapstructure:"service-account-token-file"`
+
+	UsernameKey string `mapstructure:"username-key"`
+	PasswordKey string `mapstructure:"password-key"`
+	EndpointKey string `mapstructure:"endpoint-key"`
+	// Endpoint is used when the secret doesn't contain the endpoint itself.
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// Vault represents registry credentials stored in HashiCorp Vault.
+type Vault struct {
+	config VaultConfig
+	client *http.Client
+}
+
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+// NewVault returns a pointer to Vault, authenticating with the Kubernetes auth method unless configured otherwise.
+func NewVault(config VaultConfig) (*Vault, error) {
+	if config.Address == "" {
+		config.Address = os.Getenv("VAULT_ADDR")
+	}
+
+	err := requireSettings(setting{"address", config.Address}, setting{"path", config.Path})
+	if err != nil {
+		return nil, err
+	}
+
+	if config.AuthMethod == "" {
+		config.AuthMethod = VaultAuthKubernetes
+	}
+
+	switch config.AuthMethod {
+	case VaultAuthKubernetes:
+		err = requireSettings(setting{"role of the kubernetes auth method", config.Role})
+		if err != nil {
+			return nil, err
+		}
+	case VaultAuthToken:
+	default:
+		return nil, fmt.Errorf("unknown auth method %s", config.AuthMethod)
+	}
+
+	if config.AuthMount == "" {
+		config.AuthMount = config.AuthMethod
+	}
+
+	if config.ServiceAccountTokenFile == "" {
+		config.ServiceAccountTokenFile = ServiceAccountTokenFile
+	}
+
+	if config.UsernameKey == "" {
+		config.UsernameKey = "username"
+	}
+
+	if config.PasswordKey == "" {
+		config.PasswordKey = "password"
+	}
+
+	if config.EndpointKey == "" {
+		config.EndpointKey = "endpoint"
+	}
+
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	config.Path = strings.Trim(config.Path, "/")
+
+	return &Vault{
+		config: config,
+		client: newHTTPClient(),
+	}, nil
+}
+
+// Login reads the credentials from Vault. Dynamic secrets expire with their lease.
+func (v *Vault) Login() ([]*Credentials, error) {
+	token, err := v.authenticate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate on Vault: %w", err)
+	}
+
+	secret := &vaultResponse{}
+
+	err = v.request(http.MethodGet, v.config.Path, token, nil, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read [%s] from Vault: %w", v.config.Path, err)
+	}
+
+	// The KV v2 engine nests the secret data next to its metadata
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+
+	username, _ := data[v.config.UsernameKey].(string)
+	password, _ := data[v.config.PasswordKey].(string)
+
+	endpoint, _ := data[v.config.EndpointKey].(string)
+	if endpoint == "" {
+		endpoint = v.config.Endpoint
+	}
+
+	if username == "" || password == "" || endpoint == "" {
+		return nil, fmt.Errorf("the secret [%s] must contain a username, password and endpoint", v.config.Path)
+	}
+
+	credentials := NewCredentials(username, password, endpoint)
+	if secret.LeaseDuration > 0 {
+		credentials.ExpiresAt = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
+	}
+
+	return []*Credentials{credentials}, nil
+}
+
+func (v *Vault) authenticate() (string, error) {
+	if v.config.AuthMethod == VaultAuthToken {
+		token := os.Getenv("VAULT_TOKEN")
+		if token == "" {
+			return "", fmt.Errorf("could not find environment value for VAULT_TOKEN")
+		}
+
+		return token, nil
+	}
+
+	// The ServiceAccount token is read on every login, as Kubernetes rotates it
+	jwt, err := os.ReadFile(v.config.ServiceAccountTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the ServiceAccount token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"role": v.config.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall the login request: %w", err)
+	}
+
+	login := &vaultResponse{}
+
+	err = v.request(http.MethodPost, fmt.Sprintf("auth/%s/login", v.config.AuthMount), "", bytes.NewReader(body), login)
+	if err != nil {
+		return "", err
+	}
+
+	if login.Auth.ClientToken == "" {
+		return "", fmt.Errorf("no client token returned")
+	}
+
+	return login.Auth.ClientToken, nil
+}
+
+// Performs a request on the Vault API and decodes the response into result.
+func (v *Vault) request(method, path, token string, body io.Reader, result interface{}) error {
+	request, err := newRequest(method, fmt.Sprintf("%s/v1/%s", v.config.Address, path), body)
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+
+	_, err = doRequest(v.client, request, http.StatusOK, result)
+
+	return err
+}
@@ -0,0 +1,139 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"registry-secret-manager/pkg/registry"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a stand-in for Vault with the Kubernetes auth method and a KV v2 and a dynamic secret.
+func newFakeVaultServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/kubernetes/login", func(writer http.ResponseWriter, request *http.Request) {
+		body := map[string]string{}
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+
+		if body["role"] != "registry-secret-manager" || body["jwt"] != "service-account-token" {
+			writer.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte(`{"auth":{"client_token":"kubernetes-token"}}`))
+	})
+	mux.HandleFunc("/v1/secret/data/registry", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Vault-Token") != "kubernetes-token" {
+			writer.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte(`{"lease_duration":0,"data":{` +
+			`"data":{"username":"user","password":"pass","endpoint":"https://foo.bar"},` +
+			`"metadata":{"version":3}}}`))
+	})
+	mux.HandleFunc("/v1/harbor/creds/robot", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Vault-Token") != "static-token" {
+			writer.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, _ = writer.Write([]byte(`{"lease_duration":3600,"data":{"username":"robot","password":"secret"}}`))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestVaultLoginKubernetes(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeVaultServer(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("service-account-token\n"), 0o600))
+
+	vault, err := registry.NewVault(registry.VaultConfig{
+		Address:                 server.URL,
+		Path:                    "secret/data/registry",
+		Role:                    "registry-secret-manager",
+		ServiceAccountTokenFile: tokenFile,
+	})
+	assert.NoError(t, err)
+
+	credentials, err := vault.Login()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*registry.Credentials{registry.NewCredentials("user", "pass", "https://foo.bar")}, credentials)
+}
+
+//nolint:paralleltest // Uses environment variables for the token
+func TestVaultLoginTokenWithLease(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "static-token")
+
+	server := newFakeVaultServer(t)
+
+	vault, err := registry.NewVault(registry.VaultConfig{
+		Address:    server.URL,
+		Path:       "harbor/creds/robot",
+		AuthMethod: registry.VaultAuthToken,
+		Endpoint:   "harbor.example.com",
+	})
+	assert.NoError(t, err)
+
+	credentials, err := vault.Login()
+
+	assert.NoError(t, err)
+	assert.Len(t, credentials, 1)
+	assert.Equal(t, "robot", credentials[0].Username)
+	assert.Equal(t, "secret", credentials[0].Password)
+	assert.Equal(t, "harbor.example.com", credentials[0].Endpoint)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), credentials[0].ExpiresAt, time.Minute)
+}
+
+func TestVaultLoginDenied(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeVaultServer(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("someone-else"), 0o600))
+
+	vault, err := registry.NewVault(registry.VaultConfig{
+		Address:                 server.URL,
+		Path:                    "secret/data/registry",
+		Role:                    "registry-secret-manager",
+		ServiceAccountTokenFile: tokenFile,
+	})
+	assert.NoError(t, err)
+
+	_, err = vault.Login()
+
+	assert.Error(t, err)
+}
+
+func TestVaultInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, config := range []registry.VaultConfig{
+		{Address: "http://vault:8200"},
+		{Address: "http://vault:8200", Path: "secret/data/registry"},
+		{Address: "http://vault:8200", Path: "secret/data/registry", AuthMethod: "userpass"},
+	} {
+		_, err := registry.NewVault(config)
+
+		assert.Error(t, err)
+	}
+}
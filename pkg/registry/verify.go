@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"registry-secret-manager/pkg/image"
+	"strings"
+	"sync"
+)
+
+// VerifyManifestTypes are accepted on the manifest requests of the canary images.
+var VerifyManifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Verify wraps a Registry and checks its Credentials against the registry API after each login, so credentials which
+// don't work never replace the ones of the Secrets. Credentials which passed the verification aren't checked again,
+// as they are the ones the Secrets hold already.
+type Verify struct {
+	registry Registry
+	canaries []*image.Reference
+	client   *http.Client
+
+	mutex    sync.Mutex
+	verified map[string]bool
+}
+
+type verifyTokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// NewVerify returns a pointer to Verify. The canary images are pulled with the credentials of their registry, so
+// credentials which can log in but not pull are refused as well.
+func NewVerify(registry Registry, canaries []string) (*Verify, error) {
+	references := make([]*image.Reference, 0, len(canaries))
+
+	for _, canary := range canaries {
+		reference, err := image.Parse(canary)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary image: %w", err)
+		}
+
+		references = append(references, reference)
+	}
+
+	return &Verify{
+		registry: registry,
+		canaries: references,
+		client:   newHTTPClient(),
+	}, nil
+}
+
+// Login returns the Credentials of the wrapped Registry, or an error when any of them fails the verification.
+func (v *Verify) Login() ([]*Credentials, error) {
+	credentials, err := v.registry.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	// Only the credentials of the last login are remembered, the ones they replaced aren't returned anymore
+	verified := make(map[string]bool, len(credentials))
+
+	for _, c := range credentials {
+		key := verificationKey(c)
+
+		if !v.verified[key] {
+			err = v.verify(c)
+			if err != nil {
+				return nil, fmt.Errorf("credentials of [%s] failed the verification: %w", c.Endpoint, err)
+			}
+		}
+
+		verified[key] = true
+	}
+
+	v.verified = verified
+
+	return credentials, nil
+}
+
+// Unwrap returns the wrapped Registry.
+func (v *Verify) Unwrap() Registry {
+	return v.registry
+}
+
+// Checks the API version with the credentials, and fetches the manifests of the canary images of their registry.
+func (v *Verify) verify(credentials *Credentials) error {
+	baseURL := verifyBaseURL(credentials.Endpoint)
+
+	err := v.request(credentials, http.MethodGet, baseURL+"/v2/", "")
+	if err != nil {
+		return fmt.Errorf("API version check failed: %w", err)
+	}
+
+	for _, canary := range v.canaries {
+		if !canary.Matches(credentials.Endpoint) {
+			continue
+		}
+
+		reference := canary.Tag
+		if canary.Digest != "" {
+			reference = canary.Digest
+		}
+
+		manifestURL := baseURL + "/v2/" + canary.Repository + "/manifests/" + reference
+
+		err = v.request(credentials, http.MethodHead, manifestURL, "repository:"+canary.Repository+":pull")
+		if err != nil {
+			return fmt.Errorf("canary image %s check failed: %w", canary, err)
+		}
+	}
+
+	return nil
+}
+
+// Performs the request anonymously first, and authenticated as the registry challenges us to. Registries answering
+// anonymous requests get the credentials with basic auth, so that they still reject the invalid ones.
+func (v *Verify) request(credentials *Credentials, method, requestURL, scope string) error {
+	err := v.do(method, requestURL, "")
+
+	scheme, params := "basic", map[string]string{}
+
+	var rejected *statusError
+	if errors.As(err, &rejected) && rejected.status == http.StatusUnauthorized {
+		scheme, params = parseChallenge(rejected.header.Get("WWW-Authenticate"))
+	} else if err != nil {
+		return fmt.Errorf("request to [%s] failed: %w", requestURL, err)
+	}
+
+	var authorization string
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		token := base64.StdEncoding.EncodeToString([]byte(credentials.Username + ":" + credentials.Password))
+		authorization = "Basic " + token
+	case "bearer":
+		token, err := v.fetchToken(credentials, params["realm"], params["service"], scope)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve a token from [%s]: %w", params["realm"], err)
+		}
+
+		authorization = "Bearer " + token
+	default:
+		return fmt.Errorf("unsupported challenge: %s", rejected.header.Get("WWW-Authenticate"))
+	}
+
+	err = v.do(method, requestURL, authorization)
+	if err != nil {
+		return fmt.Errorf("request to [%s] failed: %w", requestURL, err)
+	}
+
+	return nil
+}
+
+func (v *Verify) do(method, requestURL, authorization string) error {
+	request, err := newRequest(method, requestURL, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", strings.Join(VerifyManifestTypes, ", "))
+
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	_, err = doRequest(v.client, request, http.StatusOK, nil)
+
+	return err
+}
+
+// Requests a bearer token for the scope from the token service, the registry token of the credentials is used as is.
+func (v *Verify) fetchToken(credentials *Credentials, realm, service, scope string) (string, error) {
+	if credentials.RegistryToken != "" {
+		return credentials.RegistryToken, nil
+	}
+
+	query := url.Values{"service": {service}}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+
+	request, err := newRequest(http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	request.SetBasicAuth(credentials.Username, credentials.Password)
+
+	token := &verifyTokenResponse{}
+
+	_, err = doRequest(v.client, request, http.StatusOK, token)
+	if err != nil {
+		return "", err
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("no token returned")
+}
+
+// Returns a hash of everything the verification of the credentials depends on, so the secrets aren't kept in memory.
+func verificationKey(credentials *Credentials) string {
+	hash := sha256.New()
+
+	for _, field := range []string{
+		credentials.Endpoint,
+		credentials.Username,
+		credentials.Password,
+		credentials.IdentityToken,
+		credentials.RegistryToken,
+	} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Returns the base URL of the registry API for an endpoint, eg: https://registry-1.docker.io for Docker Hub.
+func verifyBaseURL(endpoint string) string {
+	scheme, host, _ := image.SplitEndpoint(endpoint)
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	if image.NormalizeHost(host) == image.DockerHubRegistry {
+		host = image.DockerHubAPIHost
+	}
+
+	return scheme + "://" + host
+}
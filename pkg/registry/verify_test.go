@@ -0,0 +1,223 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"registry-secret-manager/pkg/registry"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a stand-in for a registry challenging to its token service, which only hands out tokens to "user:pass" and
+// only lets them pull the "werkspot/app" repository.
+func newFakeRegistryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		username, password, _ := request.BasicAuth()
+		if username != "user" || password != "pass" {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		assert.Equal(t, "fake-registry", request.URL.Query().Get("service"))
+
+		_ = json.NewEncoder(writer).Encode(map[string]string{"token": "token:" + request.URL.Query().Get("scope")})
+	})
+	mux.HandleFunc("/v2/", func(writer http.ResponseWriter, request *http.Request) {
+		authorization := request.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer token:") {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="fake-registry"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		switch {
+		case request.URL.Path == "/v2/":
+			writer.WriteHeader(http.StatusOK)
+		case request.Method == http.MethodHead && request.URL.Path == "/v2/werkspot/app/manifests/1.0":
+			if authorization != "Bearer token:repository:werkspot/app:pull" {
+				writer.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			writer.WriteHeader(http.StatusOK)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestVerifyLogin(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeRegistryServer(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name     string
+		password string
+		canaries []string
+		valid    bool
+	}{
+		{
+			name:     "valid credentials",
+			password: "pass",
+			valid:    true,
+		},
+		{
+			name:     "invalid credentials",
+			password: "expired",
+			valid:    false,
+		},
+		{
+			name:     "canary image pulled",
+			password: "pass",
+			canaries: []string{host + "/werkspot/app:1.0", "ghcr.io/werkspot/other:1.0"},
+			valid:    true,
+		},
+		{
+			name:     "canary image missing",
+			password: "pass",
+			canaries: []string{host + "/werkspot/app:2.0"},
+			valid:    false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			credentials := registry.NewCredentials("user", test.password, server.URL)
+
+			verify, err := registry.NewVerify(&stubRegistry{credentials: []*registry.Credentials{credentials}}, test.canaries)
+			assert.NoError(t, err)
+
+			verified, err := verify.Login()
+
+			if test.valid {
+				assert.NoError(t, err)
+				assert.Equal(t, []*registry.Credentials{credentials}, verified)
+			} else {
+				assert.Error(t, err)
+				assert.Nil(t, verified)
+			}
+		})
+	}
+}
+
+func TestVerifyLoginBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		username, password, ok := request.BasicAuth()
+		if !ok || username != "AWS" || password != "token" {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="https://123456789012.dkr.ecr.eu-west-1.amazonaws.com/"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	for password, valid := range map[string]bool{"token": true, "expired": false} {
+		verify, err := registry.NewVerify(&stubRegistry{credentials: []*registry.Credentials{
+			registry.NewCredentials("AWS", password, server.URL),
+		}}, nil)
+		assert.NoError(t, err)
+
+		_, err = verify.Login()
+
+		assert.Equal(t, valid, err == nil, password)
+	}
+}
+
+func TestVerifyLoginAnonymousRegistry(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		username, password, ok := request.BasicAuth()
+		if ok && (username != "user" || password != "pass") {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	for password, valid := range map[string]bool{"pass": true, "expired": false} {
+		verify, err := registry.NewVerify(&stubRegistry{credentials: []*registry.Credentials{
+			registry.NewCredentials("user", password, server.URL),
+		}}, nil)
+		assert.NoError(t, err)
+
+		_, err = verify.Login()
+
+		assert.Equal(t, valid, err == nil, password)
+	}
+}
+
+func TestVerifyLoginVerifiedOnce(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	stub := &stubRegistry{credentials: []*registry.Credentials{registry.NewCredentials("user", "pass", server.URL)}}
+
+	verify, err := registry.NewVerify(stub, nil)
+	assert.NoError(t, err)
+
+	_, err = verify.Login()
+	assert.NoError(t, err)
+
+	verifiedRequests := atomic.LoadInt32(&requests)
+	assert.Greater(t, verifiedRequests, int32(0))
+
+	// The same credentials aren't verified again
+	_, err = verify.Login()
+	assert.NoError(t, err)
+	assert.Equal(t, verifiedRequests, atomic.LoadInt32(&requests))
+
+	// New credentials are
+	stub.credentials = []*registry.Credentials{registry.NewCredentials("user", "new", server.URL)}
+
+	_, err = verify.Login()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*verifiedRequests, atomic.LoadInt32(&requests))
+	assert.Equal(t, 3, stub.logins)
+}
+
+func TestVerifyInvalidCanary(t *testing.T) {
+	t.Parallel()
+
+	_, err := registry.NewVerify(&stubRegistry{}, []string{"ghcr.io/Werkspot/App"})
+
+	assert.Error(t, err)
+}
@@ -1,26 +1,31 @@
 package secret
 
 import (
+	"context"
 	"fmt"
 	"registry-secret-manager/pkg/registry"
 
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // NewController initializes a secret controller.
-func NewController(mgr manager.Manager, registries []registry.Registry) error {
+func NewController(mgr manager.Manager, registries []registry.Registry, options Options) error {
 	// Setup the reconciler
 	secretController, err := controller.New("secret", mgr, controller.Options{
-		Reconciler: NewReconciler(mgr.GetClient(), registries),
+		Reconciler: NewReconciler(mgr.GetClient(), registries, options),
 	})
 	if err != nil {
 		return fmt.Errorf("unable to set up Secret controller: %w", err)
@@ -42,14 +47,21 @@ func NewController(mgr manager.Manager, registries []registry.Registry) error {
 		&source.Kind{
 			Type: &corev1.Secret{},
 		},
-		&handler.EnqueueRequestForObject{},
+		EnqueueDueSecrets(options),
 		labelSelector,
 		predicate.Funcs{
 			// Skip everything but the create event, we want to have an initial reconciliation (create event), and keep
 			// on periodically reconciling. But we don't need to reconcile update as it already contains the correct/desired
 			// registry credentials. Otherwise it will end up in a loop.
 			// This could be improved, however, by checking the contents of the secret and if its due for renewing.
+			// Only a refresh requested on demand is reconciled right away.
 			UpdateFunc: func(event event.UpdateEvent) bool {
+				if isRefreshRequested(event.ObjectOld, event.ObjectNew) {
+					log.Infof("Refresh of the Secret [%s/%s] requested", event.ObjectNew.GetNamespace(), event.ObjectNew.GetName())
+
+					return true
+				}
+
 				log.Debugf(
 					"Skipping reconciliation of Secret [%s/%s] as it has just been updated",
 					event.ObjectNew.GetNamespace(),
@@ -82,5 +94,114 @@ func NewController(mgr manager.Manager, registries []registry.Registry) error {
 		return fmt.Errorf("unable to watch Secrets: %w", err)
 	}
 
+	// Refresh the managed Secrets of a Namespace on demand
+	err = secretController.Watch(
+		&source.Kind{
+			Type: &corev1.Namespace{},
+		},
+		handler.EnqueueRequestsFromMapFunc(EnqueueNamespaceSecrets(mgr.GetClient(), registries)),
+		predicate.Funcs{
+			CreateFunc: func(event event.CreateEvent) bool {
+				return false
+			},
+			UpdateFunc: func(event event.UpdateEvent) bool {
+				return isRefreshRequested(event.ObjectOld, event.ObjectNew)
+			},
+			DeleteFunc: func(event event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(event event.GenericEvent) bool {
+				return false
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to watch Namespaces: %w", err)
+	}
+
+	// Propagate any change of the source Secrets or files to every managed Secret
+	for _, r := range registries {
+		switch watched := registry.Unwrap(r).(type) {
+		case *registry.KubernetesSecret:
+			err = secretController.Watch(
+				&source.Kind{
+					Type: &corev1.Secret{},
+				},
+				handler.EnqueueRequestsFromMapFunc(EnqueueManagedSecrets(mgr.GetClient())),
+				predicate.NewPredicateFuncs(watched.IsSource),
+			)
+			if err != nil {
+				return fmt.Errorf("unable to watch the source Secrets: %w", err)
+			}
+		case *registry.File:
+			err = secretController.Watch(
+				&source.Channel{
+					Source: watched.Events(),
+				},
+				handler.EnqueueRequestsFromMapFunc(EnqueueManagedSecrets(mgr.GetClient())),
+			)
+			if err != nil {
+				return fmt.Errorf("unable to watch the credentials files: %w", err)
+			}
+		}
+	}
+
 	return nil
 }
+
+// EnqueueDueSecrets returns a handler reconciling right away the Secrets rendered with another configuration (eg: on
+// startup after a registry was removed or the credentials in the environment changed), whose credentials are about to
+// expire or expired (eg: after an outage), or whose refresh was requested. The others are only reconciled once they are
+// due, so restarting the manager doesn't render every Secret again.
+func EnqueueDueSecrets(options Options) handler.EventHandler {
+	enqueue := &handler.EnqueueRequestForObject{}
+
+	return handler.Funcs{
+		CreateFunc: func(event event.CreateEvent, queue workqueue.RateLimitingInterface) {
+			secret, ok := event.Object.(*corev1.Secret)
+			if !ok || IsOutdated(secret, options) || isDue(secret) {
+				enqueue.Create(event, queue)
+
+				return
+			}
+
+			request := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: secret.Namespace,
+					Name:      secret.Name,
+				},
+			}
+
+			queue.AddAfter(request, requeueAfter(secret))
+		},
+		UpdateFunc:  enqueue.Update,
+		DeleteFunc:  enqueue.Delete,
+		GenericFunc: enqueue.Generic,
+	}
+}
+
+// EnqueueManagedSecrets returns a function listing every managed Secret to reconcile.
+func EnqueueManagedSecrets(reader client.Reader) handler.MapFunc {
+	return func(object client.Object) []reconcile.Request {
+		secrets, err := ListManagedSecrets(context.Background(), reader, "")
+		if err != nil {
+			log.Errorf("Failed to list the Secrets to refresh after [%s/%s] changed: %v", object.GetNamespace(), object.GetName(), err)
+
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(secrets))
+		for _, secret := range secrets {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: secret.Namespace,
+					Name:      secret.Name,
+				},
+			})
+		}
+
+		log.Infof("Refreshing %d Secrets after [%s/%s] changed", len(requests), object.GetNamespace(), object.GetName())
+
+		return requests
+	}
+}
@@ -0,0 +1,128 @@
+package secret_test
+
+import (
+	"context"
+	"registry-secret-manager/pkg/registry"
+	"registry-secret-manager/pkg/secret"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestEnqueueManagedSecrets(t *testing.T) {
+	t.Parallel()
+
+	managedLabels := map[string]string{
+		"app.kubernetes.io/name": "registry-secret-manager",
+		"registry-secret":        "true",
+	}
+
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "registry-secret-manager", Name: "source"},
+	}
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		source,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: secret.Name, Labels: managedLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: secret.Name, Labels: managedLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "unrelated"}},
+	).Build()
+
+	requests := secret.EnqueueManagedSecrets(fakeClient)(source)
+
+	assert.ElementsMatch(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: "foo", Name: secret.Name}},
+		{NamespacedName: types.NamespacedName{Namespace: "bar", Name: secret.Name}},
+	}, requests)
+}
+
+func TestEnqueueDueSecrets(t *testing.T) {
+	t.Parallel()
+
+	configHash, err := secret.HashConfig(map[string]interface{}{"registry": []string{"docker-hub"}})
+	assert.NoError(t, err)
+
+	options := secret.Options{ConfigHash: configHash}
+
+	newSecret := func(name, configHash string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "foo",
+			Name:        name,
+			Annotations: map[string]string{secret.ConfigHashAnnotation: configHash},
+		}}
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	handler := secret.EnqueueDueSecrets(options)
+	handler.Create(event.CreateEvent{Object: newSecret("current", configHash)}, queue)
+	handler.Create(event.CreateEvent{Object: newSecret("outdated", "removed-registry")}, queue)
+	handler.Create(event.CreateEvent{Object: newSecret("older-version", "")}, queue)
+
+	// Only the outdated Secrets are reconciled right away
+	assert.Equal(t, 2, queue.Len())
+
+	for i := 0; i < 2; i++ {
+		item, _ := queue.Get()
+		assert.NotEqual(t, "current", item.(reconcile.Request).Name)
+	}
+
+	// Once reconciled, the Secret holds the current credentials and records the current configuration
+	outdated := newSecret(secret.Name, "removed-registry")
+	outdated.Type = corev1.SecretTypeDockerConfigJson
+	outdated.Data = map[string][]byte{
+		corev1.DockerConfigJsonKey: []byte(`{"auths":{"https://foo.bar":{"username":"user","password":"old","auth":"dXNlcjpvbGQ="}}}`),
+	}
+	fakeClient := fake.NewClientBuilder().WithObjects(outdated).Build()
+
+	_, err = secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{}}, options).Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: outdated.Namespace, Name: outdated.Name},
+	})
+	assert.NoError(t, err)
+
+	reconciled := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: outdated.Namespace, Name: outdated.Name}, reconciled))
+	assert.Contains(t, reconciled.StringData[corev1.DockerConfigJsonKey], `"password":"pass"`)
+	assert.False(t, secret.IsOutdated(reconciled, options))
+}
+
+func TestHashConfig(t *testing.T) {
+	t.Parallel()
+
+	config := map[string]interface{}{
+		"registry": []string{"docker-hub", "ecr"},
+		"ecr":      map[string]interface{}{"accounts": []interface{}{map[interface{}]interface{}{"region": "eu-west-1"}}},
+	}
+	same := map[string]interface{}{
+		"ecr":      map[string]interface{}{"accounts": []interface{}{map[interface{}]interface{}{"region": "eu-west-1"}}},
+		"registry": []string{"docker-hub", "ecr"},
+	}
+	removed := map[string]interface{}{
+		"registry": []string{"docker-hub"},
+	}
+	changedCredentials := map[string]interface{}{
+		"registry":    []string{"docker-hub", "ecr"},
+		"ecr":         map[string]interface{}{"accounts": []interface{}{map[interface{}]interface{}{"region": "eu-west-1"}}},
+		"environment": map[string]string{"DOCKER_HUB_PASSWORD": "changed"},
+	}
+
+	hash := func(config interface{}) string {
+		hash, err := secret.HashConfig(config)
+		assert.NoError(t, err)
+
+		return hash
+	}
+
+	assert.Equal(t, hash(config), hash(same))
+	assert.NotEqual(t, hash(config), hash(removed))
+	assert.NotEqual(t, hash(config), hash(changedCredentials))
+}
@@ -2,14 +2,17 @@ package secret
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"registry-secret-manager/pkg/image"
 	"registry-secret-manager/pkg/registry"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
-// Having an email set in the configuration is mandatory and without it the authentication will fail.
-// However, any valid email can be used.
-// See: https://github.com/kubernetes/kubernetes/issues/41727
-const DefaultEmail = "technology@werkspot.nl"
+// DockerHubKey is the legacy key of Docker Hub, looked up by the Docker CLI, go-containerregistry and the kubelet.
+const DockerHubKey = "https://index.docker.io/v1/"
 
 // DockerConfig stores a map of valid Authorization.
 type DockerConfig struct {
@@ -18,13 +21,24 @@ type DockerConfig struct {
 
 // Authorization contains a valid set of credentials.
 type Authorization struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email    string `json:"email"`
-	Auth     string `json:"auth"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
 }
 
-// NewDockerConfig returns a pointer to DockerConfig.
+// NewDockerConfig returns a pointer to DockerConfig, with an Authorization for the endpoint of the credentials as is
+// and the normalized keys of their endpoint and aliases. Each runtime looks the credentials up differently:
+//   - the kubelet (for containerd and CRI-O) matches the image host and path with keys with or without scheme, and
+//     Docker Hub images with DockerHubKey or "index.docker.io";
+//   - the Docker CLI and go-containerregistry (eg: kaniko, crane) look Docker Hub up as DockerHubKey, and other
+//     registries by their host;
+//   - containers/image (eg: podman, buildah, skopeo) looks Docker Hub up as "docker.io", and other registries by
+//     their host without scheme;
+//   - containerd clients (eg: nerdctl) resolve Docker Hub to "registry-1.docker.io".
 func NewDockerConfig(registryCredentials []*registry.Credentials) *DockerConfig {
 	authorizations := map[string]Authorization{}
 
@@ -32,11 +46,20 @@ func NewDockerConfig(registryCredentials []*registry.Credentials) *DockerConfig
 		token := fmt.Sprintf("%s:%s", credentials.Username, credentials.Password)
 		tokenBytes := []byte(token)
 
-		authorizations[credentials.Endpoint] = Authorization{
+		authorization := Authorization{
 			Username: credentials.Username,
 			Password: credentials.Password,
-			Email:    DefaultEmail,
+			Email:    credentials.Email,
 			Auth:     base64.StdEncoding.EncodeToString(tokenBytes),
+
+			IdentityToken: credentials.IdentityToken,
+			RegistryToken: credentials.RegistryToken,
+		}
+
+		authorizations[credentials.Endpoint] = authorization
+
+		for _, endpoint := range append([]string{credentials.Endpoint}, credentials.Aliases...) {
+			authorizations[NormalizeKey(endpoint)] = authorization
 		}
 	}
 
@@ -44,3 +67,87 @@ func NewDockerConfig(registryCredentials []*registry.Credentials) *DockerConfig
 		Authorizations: authorizations,
 	}
 }
+
+// Encode returns the key and value of the docker config for the given Secret type, the legacy
+// kubernetes.io/dockercfg type only holds the authorizations.
+func (d *DockerConfig) Encode(secretType corev1.SecretType) (string, []byte, error) {
+	switch secretType {
+	case corev1.SecretTypeDockerConfigJson:
+		value, err := json.Marshal(d)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshall json: %w", err)
+		}
+
+		return corev1.DockerConfigJsonKey, value, nil
+	case corev1.SecretTypeDockercfg:
+		value, err := json.Marshal(d.Authorizations)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshall json: %w", err)
+		}
+
+		return corev1.DockerConfigKey, value, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported Secret type %s", secretType)
+	}
+}
+
+// DecodeDockerConfig returns the docker config of a Secret of the given type.
+func DecodeDockerConfig(secretType corev1.SecretType, value []byte) (*DockerConfig, error) {
+	dockerConfig := &DockerConfig{}
+
+	var err error
+
+	switch secretType {
+	case corev1.SecretTypeDockerConfigJson:
+		err = json.Unmarshal(value, dockerConfig)
+	case corev1.SecretTypeDockercfg:
+		err = json.Unmarshal(value, &dockerConfig.Authorizations)
+	default:
+		return nil, fmt.Errorf("unsupported Secret type %s", secretType)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshall json: %w", err)
+	}
+
+	return dockerConfig, nil
+}
+
+// DecodeSecret returns the docker config of a Secret of either type.
+func DecodeSecret(secret *corev1.Secret) (*DockerConfig, error) {
+	key := corev1.DockerConfigJsonKey
+	if secret.Type == corev1.SecretTypeDockercfg {
+		key = corev1.DockerConfigKey
+	}
+
+	value, ok := secret.Data[key]
+	if !ok {
+		value = []byte(secret.StringData[key])
+	}
+
+	return DecodeDockerConfig(secret.Type, value)
+}
+
+// Keys returns the sorted keys of the authorizations.
+func (d *DockerConfig) Keys() []string {
+	keys := make([]string, 0, len(d.Authorizations))
+	for key := range d.Authorizations {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// NormalizeKey strips the scheme, the API version and the trailing slash of an endpoint, except for Docker Hub whose
+// legacy key is kept as most clients only look that one up.
+func NormalizeKey(endpoint string) string {
+	_, host, path := image.SplitEndpoint(endpoint)
+
+	if host == "index.docker.io" && path == "" {
+		return DockerHubKey
+	}
+
+	return image.JoinEndpoint(host, path)
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestNewDockerConfig(t *testing.T) {
@@ -31,7 +32,9 @@ func TestNewDockerConfig(t *testing.T) {
 					Endpoint: "https://foo.bar",
 				},
 			},
-			expected: `{"auths":{"https://foo.bar":{"username":"user","password":"pass","email":"` + secret.DefaultEmail + `","auth":"dXNlcjpwYXNz"}}}`,
+			expected: `{"auths":{` +
+				`"foo.bar":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"},` +
+				`"https://foo.bar":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"}}}`,
 		},
 		{
 			name: "more than one credential",
@@ -48,8 +51,51 @@ func TestNewDockerConfig(t *testing.T) {
 				},
 			},
 			expected: `{"auths":{` +
-				`"https://foo.bar/one":{"username":"one","password":"pass","email":"` + secret.DefaultEmail + `","auth":"b25lOnBhc3M="},` +
-				`"https://foo.bar/two":{"username":"two","password":"pass","email":"` + secret.DefaultEmail + `","auth":"dHdvOnBhc3M="}}}`,
+				`"foo.bar/one":{"username":"one","password":"pass","auth":"b25lOnBhc3M="},` +
+				`"foo.bar/two":{"username":"two","password":"pass","auth":"dHdvOnBhc3M="},` +
+				`"https://foo.bar/one":{"username":"one","password":"pass","auth":"b25lOnBhc3M="},` +
+				`"https://foo.bar/two":{"username":"two","password":"pass","auth":"dHdvOnBhc3M="}}}`,
+		},
+		{
+			name: "tokens",
+			credentials: []*registry.Credentials{
+				{
+					Username:      "robot",
+					Password:      "pass",
+					Endpoint:      "harbor.example.com",
+					IdentityToken: "refresh",
+					RegistryToken: "bearer",
+				},
+			},
+			expected: `{"auths":{"harbor.example.com":{"username":"robot","password":"pass",` +
+				`"auth":"cm9ib3Q6cGFzcw==","identitytoken":"refresh","registrytoken":"bearer"}}}`,
+		},
+		{
+			name: "email",
+			credentials: []*registry.Credentials{
+				{
+					Username: "user",
+					Password: "pass",
+					Endpoint: "foo.bar",
+					Email:    "registry@example.com",
+				},
+			},
+			expected: `{"auths":{"foo.bar":{"username":"user","password":"pass","email":"registry@example.com","auth":"dXNlcjpwYXNz"}}}`,
+		},
+		{
+			name: "aliases",
+			credentials: []*registry.Credentials{
+				{
+					Username: "user",
+					Password: "pass",
+					Endpoint: "https://index.docker.io/v1/",
+					Aliases:  registry.DockerHubAliases,
+				},
+			},
+			expected: `{"auths":{` +
+				`"docker.io":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"},` +
+				`"https://index.docker.io/v1/":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"},` +
+				`"registry-1.docker.io":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"}}}`,
 		},
 	}
 
@@ -66,3 +112,56 @@ func TestNewDockerConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeKey(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"https://index.docker.io/v1/": secret.DockerHubKey,
+		"index.docker.io":             secret.DockerHubKey,
+		"docker.io":                   "docker.io",
+		"registry-1.docker.io":        "registry-1.docker.io",
+		"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com": "123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+		"https://Harbor.Example.com:8443/v2/":                  "harbor.example.com:8443",
+		"public.ecr.aws":                                       "public.ecr.aws",
+		"ghcr.io/werkspot/":                                    "ghcr.io/werkspot",
+		"GHCR.io/Werkspot":                                     "ghcr.io/Werkspot",
+		"index.docker.io/library":                              "index.docker.io/library",
+	}
+
+	for endpoint, expected := range tests {
+		assert.Equal(t, expected, secret.NormalizeKey(endpoint), endpoint)
+	}
+}
+
+func TestDockerConfigEncode(t *testing.T) {
+	t.Parallel()
+
+	dockerConfig := secret.NewDockerConfig([]*registry.Credentials{registry.NewCredentials("user", "pass", "foo.bar")})
+
+	tests := map[corev1.SecretType]struct {
+		key   string
+		value string
+	}{
+		corev1.SecretTypeDockerConfigJson: {
+			key:   corev1.DockerConfigJsonKey,
+			value: `{"auths":{"foo.bar":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"}}}`,
+		},
+		corev1.SecretTypeDockercfg: {
+			key:   corev1.DockerConfigKey,
+			value: `{"foo.bar":{"username":"user","password":"pass","auth":"dXNlcjpwYXNz"}}`,
+		},
+	}
+
+	for secretType, expected := range tests {
+		key, value, err := dockerConfig.Encode(secretType)
+
+		assert.NoError(t, err)
+		assert.Equal(t, expected.key, key)
+		assert.Equal(t, expected.value, string(value))
+	}
+
+	_, _, err := dockerConfig.Encode(corev1.SecretTypeOpaque)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package secret_test
+
+import (
+	"context"
+	"encoding/base64"
+	"registry-secret-manager/pkg/registry"
+	"registry-secret-manager/pkg/secret"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/aws/aws-sdk-go/service/ecrpublic"
+	"github.com/aws/aws-sdk-go/service/ecrpublic/ecrpubliciface"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type stubEcrClient struct {
+	ecriface.ECRAPI
+
+	expiresAt time.Time
+}
+
+func (s *stubEcrClient) GetAuthorizationToken(*ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error) {
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []*ecr.AuthorizationData{{
+			AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("AWS:password"))),
+			ProxyEndpoint:      aws.String("https://111111111111.dkr.ecr.eu-west-1.amazonaws.com"),
+			ExpiresAt:          aws.Time(s.expiresAt),
+		}},
+	}, nil
+}
+
+type stubEcrPublicClient struct {
+	ecrpubliciface.ECRPublicAPI
+
+	expiresAt time.Time
+}
+
+func (s *stubEcrPublicClient) GetAuthorizationToken(*ecrpublic.GetAuthorizationTokenInput) (*ecrpublic.GetAuthorizationTokenOutput, error) {
+	return &ecrpublic.GetAuthorizationTokenOutput{
+		AuthorizationData: &ecrpublic.AuthorizationData{
+			AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("AWS:password"))),
+			ExpiresAt:          aws.Time(s.expiresAt),
+		},
+	}, nil
+}
+
+func TestReconcileEcrExpiry(t *testing.T) {
+	t.Parallel()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	tests := map[string]registry.Registry{
+		"ecr": registry.NewECR([]registry.EcrAccount{{Region: "eu-west-1"}}, func(registry.EcrAccount) (ecriface.ECRAPI, error) {
+			return &stubEcrClient{expiresAt: expiresAt}, nil
+		}),
+		"ecr public": registry.NewEcrPublic(func() (ecrpubliciface.ECRPublicAPI, error) {
+			return &stubEcrPublicClient{expiresAt: expiresAt}, nil
+		}),
+	}
+
+	for name, ecrRegistry := range tests {
+		ecrRegistry := ecrRegistry
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: secret.Name}}
+
+			fakeClient := fake.NewClientBuilder().WithObjects(
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: request.Namespace, Name: request.Name}},
+			).Build()
+			reconciler := secret.NewReconciler(fakeClient, []registry.Registry{ecrRegistry}, secret.Options{})
+
+			// The Secret records the expiry of the token, and is refreshed before the default interval is over
+			result, err := reconciler.Reconcile(context.TODO(), request)
+
+			assert.NoError(t, err)
+			assert.Less(t, result.RequeueAfter, secret.ReconcileAfter)
+
+			reconciled := &corev1.Secret{}
+			assert.NoError(t, fakeClient.Get(context.TODO(), request.NamespacedName, reconciled))
+			assert.Equal(t, expiresAt.Format(time.RFC3339), reconciled.Annotations[secret.ExpiresAtAnnotation])
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"context"
+	"registry-secret-manager/pkg/metrics"
+	"registry-secret-manager/pkg/registry"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/workqueue"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Enqueuer requests the creation of the Secret on a namespace without waiting for it.
+type Enqueuer interface {
+	Enqueue(namespace string)
+}
+
+// Creator creates the Secrets on a namespace, waiting for them.
+type Creator interface {
+	Create(ctx context.Context, namespace string) error
+}
+
+// IsDryRun checks if the admission request is a dry-run, which must not enqueue anything as it has no side effects.
+func IsDryRun(request admission.Request) bool {
+	return request.DryRun != nil && *request.DryRun
+}
+
+// Queue creates the requested Secrets in the background, retrying with a backoff when the creation fails.
+type Queue struct {
+	client     client.Client
+	registries []registry.Registry
+	options    Options
+
+	queue workqueue.RateLimitingInterface
+}
+
+// NewQueue returns a pointer to Queue.
+func NewQueue(client client.Client, registries []registry.Registry, options Options) *Queue {
+	return &Queue{
+		client:     client,
+		registries: registries,
+		options:    options,
+		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+// Enqueue the creation of the Secret on the given namespace.
+func (q *Queue) Enqueue(namespace string) {
+	q.queue.Add(namespace)
+	metrics.SecretQueueDepth.Set(float64(q.queue.Len()))
+}
+
+// Create the Secrets on the given namespace right away, which are then up to date until reconciled.
+func (q *Queue) Create(ctx context.Context, namespace string) error {
+	return CreateSecretsIfNeeded(ctx, q.client, q.registries, namespace, q.options)
+}
+
+// Start processing the queue until the context is done.
+func (q *Queue) Start(ctx context.Context) error {
+	go func() {
+		for q.processNextItem(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+	q.queue.ShutDown()
+
+	return nil
+}
+
+// NeedLeaderElection is false as the webhooks, and thus the requests, are served by every replica.
+func (q *Queue) NeedLeaderElection() bool {
+	return false
+}
+
+func (q *Queue) processNextItem(ctx context.Context) bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+
+	defer q.queue.Done(item)
+	defer func() {
+		metrics.SecretQueueDepth.Set(float64(q.queue.Len()))
+	}()
+
+	namespace, _ := item.(string)
+
+	err := q.Create(ctx, namespace)
+	if err != nil {
+		log.Errorf("Retrying the creation of the Secret on namespace [%s]: %v", namespace, err)
+		q.queue.AddRateLimited(item)
+
+		return true
+	}
+
+	q.queue.Forget(item)
+
+	return true
+}
@@ -0,0 +1,55 @@
+package secret_test
+
+import (
+	"context"
+	"registry-secret-manager/pkg/secret"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().Build()
+	queue := secret.NewQueue(fakeClient, nil, secret.Options{})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	go func() {
+		_ = queue.Start(ctx)
+	}()
+
+	queue.Enqueue("first")
+	queue.Enqueue("second")
+
+	// Both Secrets are eventually created in the background
+	for _, namespace := range []string{"first", "second"} {
+		secretName := types.NamespacedName{
+			Namespace: namespace,
+			Name:      "registry-secret",
+		}
+
+		assert.Eventually(t, func() bool {
+			return fakeClient.Get(context.TODO(), secretName, &corev1.Secret{}) == nil
+		}, time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestIsDryRun(t *testing.T) {
+	t.Parallel()
+
+	dryRun, notDryRun := true, false
+
+	assert.True(t, secret.IsDryRun(admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{DryRun: &dryRun}}))
+	assert.False(t, secret.IsDryRun(admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{DryRun: &notDryRun}}))
+	assert.False(t, secret.IsDryRun(admission.Request{}))
+}
@@ -9,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -16,15 +17,20 @@ import (
 
 const ReconcileAfter = 3 * time.Hour
 
+// MinReconcileAfter prevents a reconciliation loop when a registry keeps on handing out credentials about to expire.
+const MinReconcileAfter = time.Minute
+
 type Reconciler struct {
 	client     client.Client
 	registries []registry.Registry
+	options    Options
 }
 
-func NewReconciler(client client.Client, registries []registry.Registry) *Reconciler {
+func NewReconciler(client client.Client, registries []registry.Registry, options Options) *Reconciler {
 	return &Reconciler{
 		client:     client,
 		registries: registries,
+		options:    options,
 	}
 }
 
@@ -38,9 +44,9 @@ func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 	}
 
 	// Fetch the Secret from cache
-	secret := &corev1.Secret{}
+	existing := &corev1.Secret{}
 
-	err := r.client.Get(ctx, request.NamespacedName, secret)
+	err := r.client.Get(ctx, request.NamespacedName, existing)
 	if errors.IsNotFound(err) {
 		log.Debugf("Stopping reconciliation of Secret [%s] as it no longer exists: %v", request.NamespacedName, err)
 
@@ -54,8 +60,44 @@ func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 		return result, err
 	}
 
-	// Update the Secret
-	secret, err = createSecretObject(r.registries, request.Namespace)
+	// The Secret of a registry which got disabled, or of the other mode, is cleaned up
+	registries, ok := Group(r.registries, r.options)[BaseName(request.Name)]
+	if !ok {
+		return reconcile.Result{}, r.delete(ctx, existing)
+	}
+
+	// A version superseded by a newer one, or of the other mode, is collected once unused
+	next, superseded, err := r.nextVersion(ctx, existing)
+	if err != nil {
+		log.Error(err)
+
+		return result, err
+	}
+
+	if superseded {
+		return r.collect(ctx, existing, next)
+	}
+
+	if IsOutdated(existing, r.options) {
+		log.Infof("Rendering the Secret [%s] again as the configuration changed", request.NamespacedName)
+	}
+
+	// A refresh was requested, the credentials obtained before must not be reused
+	if requestedAt, ok := refreshRequestedAt(existing); ok {
+		log.Infof("Refreshing the Secret [%s] on demand", request.NamespacedName)
+
+		for _, refreshed := range registries {
+			registry.InvalidateBefore(refreshed, requestedAt)
+		}
+	}
+
+	// Update the Secret, or write its next version when immutable
+	secretName := request.NamespacedName
+	if r.options.Immutable {
+		secretName.Name = VersionedName(BaseName(request.Name), versionOf(request.Name)+1)
+	}
+
+	secret, err := createSecretObject(registries, secretName, existing, r.options)
 	if err != nil {
 		err = fmt.Errorf("could not create the Secret object [%s]: %w", request.NamespacedName, err)
 		log.Error(err)
@@ -63,7 +105,16 @@ func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 		return result, err
 	}
 
+	if r.options.Immutable {
+		return r.rotate(ctx, existing, secret)
+	}
+
 	err = r.client.Update(ctx, secret)
+	if errors.IsInvalid(err) && existing.Type != secret.Type {
+		// The type of a Secret is immutable, it is replaced when the configured type changed
+		err = r.replace(ctx, existing, secret)
+	}
+
 	if err != nil {
 		err = fmt.Errorf("could not update the Secret [%s]: %w", request.NamespacedName, err)
 		log.Error(err)
@@ -73,5 +124,202 @@ func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 
 	log.Infof("Successfully updated the Secret [%s]", request.NamespacedName)
 
+	result.RequeueAfter = requeueAfter(secret)
+
 	return result, nil
 }
+
+// Deletes a Secret which isn't desired anymore.
+func (r *Reconciler) delete(ctx context.Context, existing *corev1.Secret) error {
+	err := r.client.Delete(ctx, existing, client.Preconditions{UID: &existing.UID})
+	if err != nil && !errors.IsNotFound(err) {
+		err = fmt.Errorf("could not delete the stale Secret [%s/%s]: %w", existing.Namespace, existing.Name, err)
+		log.Error(err)
+
+		return err
+	}
+
+	log.Infof("Deleted the stale Secret [%s/%s]", existing.Namespace, existing.Name)
+
+	return nil
+}
+
+// Deletes the existing Secret and creates the desired one, the ServiceAccounts keep on referencing it by name.
+func (r *Reconciler) replace(ctx context.Context, existing, secret *corev1.Secret) error {
+	err := r.client.Delete(ctx, existing, client.Preconditions{UID: &existing.UID})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete the Secret of type %s: %w", existing.Type, err)
+	}
+
+	err = r.client.Create(ctx, secret)
+	if err != nil {
+		return fmt.Errorf("could not recreate the Secret as %s: %w", secret.Type, err)
+	}
+
+	log.Infof("Replaced the Secret [%s/%s] of type %s by %s", secret.Namespace, secret.Name, existing.Type, secret.Type)
+
+	return nil
+}
+
+// Creates the next version of an immutable Secret, unless its credentials didn't change. The ServiceAccounts are then
+// repointed to the next version, and the existing one is collected after the grace period.
+func (r *Reconciler) rotate(ctx context.Context, existing, secret *corev1.Secret) (reconcile.Result, error) {
+	result := reconcile.Result{
+		Requeue:      true,
+		RequeueAfter: ReconcileAfter,
+	}
+
+	if existing.Immutable != nil && *existing.Immutable && hasSameData(existing, secret) {
+		// Only the metadata of an immutable Secret can be updated, eg: to drop the refresh request
+		existing.Annotations = secret.Annotations
+
+		err := r.client.Update(ctx, existing)
+		if err != nil {
+			err = fmt.Errorf("could not update the Secret [%s/%s]: %w", existing.Namespace, existing.Name, err)
+			log.Error(err)
+
+			return result, err
+		}
+
+		log.Infof("No need to rotate the Secret [%s/%s] as its credentials didn't change", existing.Namespace, existing.Name)
+
+		result.RequeueAfter = requeueAfter(existing)
+
+		return result, nil
+	}
+
+	err := r.client.Create(ctx, secret)
+	if err != nil {
+		err = fmt.Errorf("could not create the Secret [%s/%s]: %w", secret.Namespace, secret.Name, err)
+		log.Error(err)
+
+		return result, err
+	}
+
+	log.Infof("Successfully rotated the Secret [%s/%s] to [%s]", existing.Namespace, existing.Name, secret.Name)
+
+	return reconcile.Result{RequeueAfter: r.options.gracePeriod()}, nil
+}
+
+// Returns the version superseding the existing Secret, if any: the next version when immutable, the unversioned Secret
+// otherwise. The versioned Secrets are superseded in the other mode, even before the unversioned Secret exists.
+func (r *Reconciler) nextVersion(ctx context.Context, existing *corev1.Secret) (*corev1.Secret, bool, error) {
+	name := BaseName(existing.Name)
+
+	if !r.options.Immutable {
+		if existing.Name == name {
+			return nil, false, nil
+		}
+
+		next := &corev1.Secret{}
+
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: existing.Namespace, Name: name}, next)
+		if errors.IsNotFound(err) {
+			return nil, true, nil
+		}
+
+		if err != nil {
+			return nil, true, fmt.Errorf("could not fetch the Secret [%s/%s]: %w", existing.Namespace, name, err)
+		}
+
+		return next, true, nil
+	}
+
+	versions, err := listVersions(ctx, r.client, existing.Namespace, name)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not list the versions of the Secret [%s/%s]: %w", existing.Namespace, name, err)
+	}
+
+	for i := range versions {
+		if versionOf(versions[i].Name) > versionOf(existing.Name) {
+			return &versions[i], true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// Deletes a superseded version once the grace period since the next version was created is over, and no ServiceAccount
+// references it anymore. The Pods created before keep on pulling their images with it until then.
+func (r *Reconciler) collect(ctx context.Context, existing, next *corev1.Secret) (reconcile.Result, error) {
+	if next == nil {
+		log.Debugf("Keeping the Secret [%s/%s] until the Secret superseding it is created", existing.Namespace, existing.Name)
+
+		return reconcile.Result{RequeueAfter: r.options.gracePeriod()}, nil
+	}
+
+	if remaining := r.options.gracePeriod() - time.Since(next.CreationTimestamp.Time); remaining > 0 {
+		log.Debugf("Keeping the superseded Secret [%s/%s] for %s", existing.Namespace, existing.Name, remaining)
+
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	referenced, err := isReferenced(ctx, r.client, existing)
+	if err != nil {
+		log.Error(err)
+
+		return reconcile.Result{RequeueAfter: MinReconcileAfter}, err
+	}
+
+	if referenced {
+		log.Infof("Keeping the superseded Secret [%s/%s] as ServiceAccounts still reference it", existing.Namespace, existing.Name)
+
+		return reconcile.Result{RequeueAfter: ReconcileAfter}, nil
+	}
+
+	return reconcile.Result{}, r.delete(ctx, existing)
+}
+
+// Returns whether the Secret holds the same data as the desired one.
+func hasSameData(existing, secret *corev1.Secret) bool {
+	if existing.Type != secret.Type {
+		return false
+	}
+
+	for key, value := range secret.StringData {
+		existingValue, ok := existing.Data[key]
+		if !ok {
+			existingValue = []byte(existing.StringData[key])
+		}
+
+		if string(existingValue) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns whether the Secret must be reconciled right away, as its credentials are about to expire or its refresh was
+// requested.
+func isDue(secret *corev1.Secret) bool {
+	if _, ok := secret.Annotations[RefreshAnnotation]; ok {
+		return true
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, secret.Annotations[ExpiresAtAnnotation])
+	if err != nil {
+		return false
+	}
+
+	return time.Until(expiresAt.Add(-registry.ExpiryMargin)) < MinReconcileAfter
+}
+
+// Returns when the Secret must be reconciled again, which is before any of its credentials expire.
+func requeueAfter(secret *corev1.Secret) time.Duration {
+	expiresAt, err := time.Parse(time.RFC3339, secret.Annotations[ExpiresAtAnnotation])
+	if err != nil {
+		return ReconcileAfter
+	}
+
+	renewIn := time.Until(expiresAt.Add(-registry.ExpiryMargin))
+
+	switch {
+	case renewIn < MinReconcileAfter:
+		return MinReconcileAfter
+	case renewIn < ReconcileAfter:
+		return renewIn
+	default:
+		return ReconcileAfter
+	}
+}
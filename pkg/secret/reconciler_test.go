@@ -2,11 +2,15 @@ package secret_test
 
 import (
 	"context"
+	"fmt"
+	"registry-secret-manager/pkg/registry"
 	"registry-secret-manager/pkg/secret"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -40,7 +44,7 @@ func TestReconcile(t *testing.T) {
 	fakeClientBuilder.WithObjects(secretObject)
 
 	fakeClient := fakeClientBuilder.Build()
-	reconciler := secret.NewReconciler(fakeClient, nil)
+	reconciler := secret.NewReconciler(fakeClient, nil, secret.Options{})
 
 	// Reconcile and verify its content
 	result, err := reconciler.Reconcile(context.TODO(), request)
@@ -57,3 +61,240 @@ func TestReconcile(t *testing.T) {
 	assert.Equal(t, "2", secretObject.ResourceVersion)
 	assert.Equal(t, corev1.SecretTypeDockerConfigJson, secretObject.Type)
 }
+
+// stubRegistry returns its credentials, or the default credentials when it has none, counting the logins.
+type stubRegistry struct {
+	credentials []*registry.Credentials
+	err         error
+	logins      int
+}
+
+func (r *stubRegistry) Login() ([]*registry.Credentials, error) {
+	r.logins++
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if r.credentials != nil {
+		return r.credentials, nil
+	}
+
+	return newCredentials("pass", "https://foo.bar"), nil
+}
+
+func newCredentials(password, endpoint string) []*registry.Credentials {
+	return []*registry.Credentials{registry.NewCredentials("user", password, endpoint)}
+}
+
+func TestReconcileExpiringCredentials(t *testing.T) {
+	t.Parallel()
+
+	request := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: "registry-secret-manager",
+			Name:      "registry-secret",
+		},
+	}
+
+	secretObject := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: request.Namespace,
+			Name:      request.Name,
+		},
+	}
+
+	credentials := newCredentials("pass", "https://foo.bar")
+	credentials[0].ExpiresAt = time.Now().Add(time.Hour)
+
+	fakeClient := fake.NewClientBuilder().WithObjects(secretObject).Build()
+	reconciler := secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{credentials: credentials}}, secret.Options{})
+
+	// The Secret is reconciled again before the credentials expire, instead of after the default interval
+	result, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.True(t, result.Requeue)
+	assert.Greater(t, result.RequeueAfter, time.Hour-registry.ExpiryMargin-time.Minute)
+	assert.LessOrEqual(t, result.RequeueAfter, time.Hour-registry.ExpiryMargin)
+
+	secretObject = &corev1.Secret{}
+	err = fakeClient.Get(context.TODO(), request.NamespacedName, secretObject)
+
+	assert.NoError(t, err)
+	assert.Contains(t, secretObject.Annotations, secret.ExpiresAtAnnotation)
+}
+
+func TestReconcileMergeForeignEntries(t *testing.T) {
+	t.Parallel()
+
+	existingDockerConfig := []byte(`{"auths":{` +
+		`"https://foo.bar":{"username":"user","password":"old","auth":"dXNlcjpvbGQ="},` +
+		`"foo.bar":{"username":"user","password":"old","auth":"dXNlcjpvbGQ="},` +
+		`"stale.example.com":{"auth":"c3RhbGU6c3RhbGU="},` +
+		`"quay.io":{"auth":"dGVhbTp0b2tlbg=="}}}`)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		keys        []string
+	}{
+		{
+			// Our entries are refreshed, our stale entry is dropped and the foreign entry is kept as is
+			name:        "owned entries recorded",
+			annotations: map[string]string{secret.OwnedAuthsAnnotation: `["https://foo.bar","foo.bar","stale.example.com"]`},
+			keys:        []string{"foo.bar", "https://foo.bar", "quay.io"},
+		},
+		{
+			// Secrets written before the owned entries were recorded only hold entries generated by us
+			name: "owned entries not recorded",
+			keys: []string{"foo.bar", "https://foo.bar"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			request := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "team",
+					Name:      "registry-secret",
+				},
+			}
+
+			secretObject := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   request.Namespace,
+					Name:        request.Name,
+					Annotations: test.annotations,
+				},
+				Type: corev1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{corev1.DockerConfigJsonKey: existingDockerConfig},
+			}
+
+			fakeClient := fake.NewClientBuilder().WithObjects(secretObject).Build()
+			reconciler := secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{}}, secret.Options{MergeForeignEntries: true})
+
+			_, err := reconciler.Reconcile(context.TODO(), request)
+
+			assert.NoError(t, err)
+
+			secretObject = &corev1.Secret{}
+			err = fakeClient.Get(context.TODO(), request.NamespacedName, secretObject)
+
+			assert.NoError(t, err)
+
+			dockerConfig, err := secret.DecodeDockerConfig(secretObject.Type, []byte(secretObject.StringData[corev1.DockerConfigJsonKey]))
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.keys, dockerConfig.Keys())
+			assert.Equal(t, "pass", dockerConfig.Authorizations["https://foo.bar"].Password)
+			assert.Equal(t, `["foo.bar","https://foo.bar"]`, secretObject.Annotations[secret.OwnedAuthsAnnotation])
+
+			if len(test.keys) > 2 {
+				assert.Equal(t, secret.Authorization{Auth: "dGVhbTp0b2tlbg=="}, dockerConfig.Authorizations["quay.io"])
+			}
+		})
+	}
+}
+
+func TestReconcileOverwritesForeignEntries(t *testing.T) {
+	t.Parallel()
+
+	request := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: "team",
+			Name:      "registry-secret",
+		},
+	}
+
+	secretObject := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: request.Namespace,
+			Name:      request.Name,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"dGVhbTp0b2tlbg=="}}}`),
+		},
+	}
+
+	fakeClient := fake.NewClientBuilder().WithObjects(secretObject).Build()
+	reconciler := secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{}}, secret.Options{})
+
+	_, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+
+	secretObject = &corev1.Secret{}
+	err = fakeClient.Get(context.TODO(), request.NamespacedName, secretObject)
+
+	assert.NoError(t, err)
+
+	dockerConfig, err := secret.DecodeDockerConfig(secretObject.Type, []byte(secretObject.StringData[corev1.DockerConfigJsonKey]))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo.bar", "https://foo.bar"}, dockerConfig.Keys())
+}
+
+func TestReconcilePerRegistry(t *testing.T) {
+	t.Parallel()
+
+	options := secret.Options{PerRegistry: true}
+
+	registries := []registry.Registry{
+		registry.NewNamed(&stubRegistry{credentials: newCredentials("pass", "ecr.example.com")}, "ecr"),
+		registry.NewNamed(&stubRegistry{credentials: newCredentials("pass", "docker-hub.example.com")}, "docker-hub"),
+	}
+
+	assert.Equal(t, []string{"registry-secret-docker-hub", "registry-secret-ecr"}, secret.Names(registries, options))
+	assert.Equal(t, []string{"registry-secret", "registry-secret-docker-hub", "registry-secret-ecr"}, secret.GeneratedNames(registries))
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry-secret"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry-secret-ecr"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry-secret-gitlab"}},
+	).Build()
+	reconciler := secret.NewReconciler(fakeClient, registries, options)
+
+	// The Secret of a registry only holds its own credentials
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "registry-secret-ecr"}}
+	_, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+
+	secretObject := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), request.NamespacedName, secretObject))
+	assert.Equal(t, `["ecr.example.com"]`, secretObject.Annotations[secret.OwnedAuthsAnnotation])
+
+	// The single Secret and the Secret of a disabled registry are cleaned up
+	for _, name := range []string{"registry-secret", "registry-secret-gitlab"} {
+		request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: name}}
+		_, err = reconciler.Reconcile(context.TODO(), request)
+
+		assert.NoError(t, err)
+		assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), request.NamespacedName, &corev1.Secret{})))
+	}
+}
+
+func TestCreateSecretsIfNeededPerRegistry(t *testing.T) {
+	t.Parallel()
+
+	options := secret.Options{PerRegistry: true}
+
+	registries := []registry.Registry{
+		registry.NewNamed(&stubRegistry{err: fmt.Errorf("failed")}, "ecr"),
+		registry.NewNamed(&stubRegistry{credentials: newCredentials("pass", "docker-hub.example.com")}, "docker-hub"),
+	}
+
+	fakeClient := fake.NewClientBuilder().Build()
+
+	// A failing registry doesn't prevent the Secrets of the others from being created
+	err := secret.CreateSecretsIfNeeded(context.TODO(), fakeClient, registries, "team", options)
+
+	assert.Error(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "registry-secret-docker-hub"}, &corev1.Secret{}))
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "registry-secret-ecr"}, &corev1.Secret{})))
+}
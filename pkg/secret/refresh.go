@@ -0,0 +1,176 @@
+package secret
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"registry-secret-manager/pkg/registry"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RefreshAnnotation requests the refresh of the Secret, or of every managed Secret of a Namespace, with a new login of
+// the registries bypassing their cache. Any new value triggers a refresh, eg: the current time as RFC 3339.
+const RefreshAnnotation = "registry-secret-manager/refresh"
+
+// Returns whether the refresh annotation was set to a new value.
+func isRefreshRequested(old, new client.Object) bool {
+	value := new.GetAnnotations()[RefreshAnnotation]
+
+	return value != "" && value != old.GetAnnotations()[RefreshAnnotation]
+}
+
+// Returns when the refresh of the Secret was requested, the credentials obtained since are fresh enough. The time is
+// unknown when the annotation isn't a timestamp, and the credentials are never fresh enough.
+func refreshRequestedAt(secret *corev1.Secret) (time.Time, bool) {
+	value, ok := secret.Annotations[RefreshAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	requestedAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Now(), true
+	}
+
+	return requestedAt, true
+}
+
+// EnqueueNamespaceSecrets returns a function forcing a new login of the registries, and listing the managed Secrets of
+// the Namespace to refresh.
+func EnqueueNamespaceSecrets(reader client.Reader, registries []registry.Registry) handler.MapFunc {
+	return func(object client.Object) []reconcile.Request {
+		for _, r := range registries {
+			registry.InvalidateBefore(r, time.Now())
+		}
+
+		secrets, err := ListManagedSecrets(context.Background(), reader, object.GetName())
+		if err != nil {
+			log.Errorf("Failed to list the Secrets of the Namespace [%s] to refresh: %v", object.GetName(), err)
+
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(secrets))
+		for _, secret := range secrets {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: secret.Namespace,
+					Name:      secret.Name,
+				},
+			})
+		}
+
+		log.Infof("Refreshing %d Secrets of the Namespace [%s] on demand", len(requests), object.GetName())
+
+		return requests
+	}
+}
+
+// RefreshHandler serves the on-demand refresh of the managed Secrets, of every namespace or of the one given as the
+// "namespace" query parameter. The Secrets are marked with the RefreshAnnotation rather than refreshed right away, so
+// the leader performs the refresh whichever replica serves the request.
+type RefreshHandler struct {
+	client client.Client
+	token  string
+}
+
+// NewRefreshHandler returns a pointer to RefreshHandler, authenticating the requests with the given bearer token.
+func NewRefreshHandler(client client.Client, token string) *RefreshHandler {
+	return &RefreshHandler{
+		client: client,
+		token:  token,
+	}
+}
+
+func (h *RefreshHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "only POST is allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	authorization := []byte(request.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(authorization, []byte("Bearer "+h.token)) != 1 {
+		http.Error(writer, "invalid bearer token", http.StatusUnauthorized)
+
+		return
+	}
+
+	namespace := request.URL.Query().Get("namespace")
+
+	secrets, err := ListManagedSecrets(request.Context(), h.client, namespace)
+	if err != nil {
+		err = fmt.Errorf("could not list the Secrets to refresh: %w", err)
+		log.Error(err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	// Every Secret gets the same time, so a single login is performed per registry
+	requestedAt := time.Now()
+
+	for i := range secrets {
+		err = RequestRefresh(request.Context(), h.client, &secrets[i], requestedAt)
+		if err != nil {
+			log.Error(err)
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	log.Infof("Requested the refresh of %d Secrets on demand", len(secrets))
+
+	writer.WriteHeader(http.StatusAccepted)
+	_, _ = fmt.Fprintf(writer, "Refreshing %d Secrets\n", len(secrets))
+}
+
+// RequestRefresh of the Secret by the leader, through the RefreshAnnotation.
+func RequestRefresh(ctx context.Context, c client.Client, secret *corev1.Secret, requestedAt time.Time) error {
+	patch := client.MergeFrom(secret.DeepCopy())
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+
+	secret.Annotations[RefreshAnnotation] = requestedAt.UTC().Format(time.RFC3339Nano)
+
+	err := c.Patch(ctx, secret, patch)
+	if err != nil {
+		return fmt.Errorf("could not request the refresh of the Secret [%s/%s]: %w", secret.Namespace, secret.Name, err)
+	}
+
+	return nil
+}
+
+// ListManagedSecrets of the given namespace, or of every namespace when empty.
+func ListManagedSecrets(ctx context.Context, reader client.Reader, namespace string) ([]corev1.Secret, error) {
+	secrets := &corev1.SecretList{}
+
+	err := reader.List(ctx, secrets, client.InNamespace(namespace), client.MatchingLabels{
+		"app.kubernetes.io/name": "registry-secret-manager",
+		"registry-secret":        "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	managed := make([]corev1.Secret, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		if IsManaged(secret.Name) {
+			managed = append(managed, secret)
+		}
+	}
+
+	return managed, nil
+}
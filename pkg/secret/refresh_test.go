@@ -0,0 +1,220 @@
+package secret_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"registry-secret-manager/pkg/registry"
+	"registry-secret-manager/pkg/secret"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var managedLabels = map[string]string{
+	"app.kubernetes.io/name": "registry-secret-manager",
+	"registry-secret":        "true",
+}
+
+func TestRefreshHandler(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: secret.Name, Labels: managedLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: secret.Name, Labels: managedLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "unrelated"}},
+	).Build()
+
+	refreshHandler := secret.NewRefreshHandler(fakeClient, "token")
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		target        string
+		status        int
+		refreshed     []string
+	}{
+		{
+			name:   "missing token",
+			method: http.MethodPost,
+			target: "/refresh",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:          "invalid token",
+			method:        http.MethodPost,
+			authorization: "Bearer invalid",
+			target:        "/refresh",
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "invalid method",
+			method:        http.MethodGet,
+			authorization: "Bearer token",
+			target:        "/refresh",
+			status:        http.StatusMethodNotAllowed,
+		},
+		{
+			name:          "single namespace",
+			method:        http.MethodPost,
+			authorization: "Bearer token",
+			target:        "/refresh?namespace=foo",
+			status:        http.StatusAccepted,
+			refreshed:     []string{"foo"},
+		},
+		{
+			name:          "every namespace",
+			method:        http.MethodPost,
+			authorization: "Bearer token",
+			target:        "/refresh",
+			status:        http.StatusAccepted,
+			refreshed:     []string{"foo", "bar"},
+		},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, nil)
+		request.Header.Set("Authorization", test.authorization)
+
+		recorder := httptest.NewRecorder()
+		refreshHandler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, test.status, recorder.Code, test.name)
+
+		for _, namespace := range test.refreshed {
+			refreshed := &corev1.Secret{}
+			assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: secret.Name}, refreshed))
+			assert.Contains(t, refreshed.Annotations, secret.RefreshAnnotation, test.name)
+		}
+	}
+
+	unrelated := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "bar", Name: "unrelated"}, unrelated))
+	assert.NotContains(t, unrelated.Annotations, secret.RefreshAnnotation)
+}
+
+func TestReconcileRefresh(t *testing.T) {
+	t.Parallel()
+
+	request := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: "team",
+			Name:      secret.Name,
+		},
+	}
+
+	stub := &stubRegistry{}
+	cache := registry.NewCache(stub, time.Hour)
+	_, _ = cache.Login()
+
+	requestedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   request.Namespace,
+			Name:        request.Name,
+			Annotations: map[string]string{secret.RefreshAnnotation: requestedAt},
+		}},
+	).Build()
+	reconciler := secret.NewReconciler(fakeClient, []registry.Registry{cache}, secret.Options{})
+
+	// The cached credentials obtained before the request aren't reused
+	_, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stub.logins)
+
+	// The annotation is consumed
+	refreshed := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), request.NamespacedName, refreshed))
+	assert.NotContains(t, refreshed.Annotations, secret.RefreshAnnotation)
+
+	// The credentials obtained since the request are reused by the other Secrets refreshed along
+	assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "other",
+		Name:        request.Name,
+		Annotations: map[string]string{secret.RefreshAnnotation: requestedAt},
+	}}))
+
+	_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: "other", Name: request.Name},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stub.logins)
+}
+
+func TestEnqueueNamespaceSecrets(t *testing.T) {
+	t.Parallel()
+
+	stub := &stubRegistry{}
+	cache := registry.NewCache(stub, time.Hour)
+	_, _ = cache.Login()
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		namespace,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: secret.Name, Labels: managedLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: secret.Name, Labels: managedLabels}},
+	).Build()
+
+	requests := secret.EnqueueNamespaceSecrets(fakeClient, []registry.Registry{cache})(namespace)
+
+	assert.Equal(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: "foo", Name: secret.Name}},
+	}, requests)
+
+	// The cached credentials aren't reused
+	_, _ = cache.Login()
+
+	assert.Equal(t, 2, stub.logins)
+}
+
+func TestEnqueueDueSecretsOnDemandOrExpiring(t *testing.T) {
+	t.Parallel()
+
+	options := secret.Options{ConfigHash: "current"}
+
+	newSecret := func(name string, annotations map[string]string) client.Object {
+		annotations[secret.ConfigHashAnnotation] = options.ConfigHash
+
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: name, Annotations: annotations}}
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	handler := secret.EnqueueDueSecrets(options)
+	handler.Create(event.CreateEvent{Object: newSecret("valid", map[string]string{
+		secret.ExpiresAtAnnotation: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})}, queue)
+	handler.Create(event.CreateEvent{Object: newSecret("expired", map[string]string{
+		secret.ExpiresAtAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+	})}, queue)
+	handler.Create(event.CreateEvent{Object: newSecret("expiring", map[string]string{
+		secret.ExpiresAtAnnotation: time.Now().Add(registry.ExpiryMargin).UTC().Format(time.RFC3339),
+	})}, queue)
+	handler.Create(event.CreateEvent{Object: newSecret("requested", map[string]string{
+		secret.RefreshAnnotation: "now",
+	})}, queue)
+
+	// Only the Secrets whose credentials expire or whose refresh was requested are reconciled right away
+	assert.Equal(t, 3, queue.Len())
+
+	for i := 0; i < 3; i++ {
+		item, _ := queue.Get()
+		assert.NotEqual(t, "valid", item.(reconcile.Request).Name)
+	}
+}
@@ -2,8 +2,14 @@ package secret
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	reg "registry-secret-manager/pkg/registry"
 
@@ -15,12 +21,197 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// CreateSecretIfNeeded on the given namespace if it doesn't already exist.
-func CreateSecretIfNeeded(ctx context.Context, client client.Client, registries []reg.Registry, namespace string) error {
-	secretName := types.NamespacedName{
-		Namespace: namespace,
-		Name:      "registry-secret",
+// Name of the Secret managed on every namespace.
+const Name = "registry-secret"
+
+// ExpiresAtAnnotation records when the first of the credentials in the Secret expires.
+const ExpiresAtAnnotation = "registry-secret-manager/expires-at"
+
+// OwnedAuthsAnnotation records the keys of the docker config generated by us, any other key is a foreign entry.
+const OwnedAuthsAnnotation = "registry-secret-manager/owned-auths"
+
+// ConfigHashAnnotation records the hash of the configuration the Secret was rendered with.
+const ConfigHashAnnotation = "registry-secret-manager/config-hash"
+
+// Options configure how the managed Secrets are rendered, they are set once on startup.
+type Options struct {
+	// Type of the Secrets, either kubernetes.io/dockerconfigjson (the default) or the legacy kubernetes.io/dockercfg.
+	Type corev1.SecretType
+	// MergeForeignEntries keeps the entries added by others to the Secrets on refresh.
+	MergeForeignEntries bool
+	// PerRegistry splits the credentials into one Secret per registry, named after the registry.
+	PerRegistry bool
+	// Immutable writes every refresh into a new immutable Secret with a versioned name, eg: registry-secret-3, instead
+	// of updating the Secret in place.
+	Immutable bool
+	// GracePeriod during which a version superseded by a newer one is kept, so that the Pods created meanwhile can
+	// still pull their images. It is at least MinReconcileAfter.
+	GracePeriod time.Duration
+	// ConfigHash of the configuration the Secrets are rendered with, see HashConfig.
+	ConfigHash string
+}
+
+// Returns the configured type of the Secrets, or the default one.
+func (o Options) secretType() corev1.SecretType {
+	if o.Type == "" {
+		return corev1.SecretTypeDockerConfigJson
+	}
+
+	return o.Type
+}
+
+// Returns the configured grace period, at least MinReconcileAfter so the superseded versions are always collected.
+func (o Options) gracePeriod() time.Duration {
+	if o.GracePeriod < MinReconcileAfter {
+		return MinReconcileAfter
+	}
+
+	return o.GracePeriod
+}
+
+// HashConfig returns the hash of the given configuration, encoded as JSON so that the keys of the maps are sorted and
+// the hash only changes along with the configuration.
+func HashConfig(config interface{}) (string, error) {
+	encoded, err := json.Marshal(canonicalConfig(config))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall json: %w", err)
+	}
+
+	hash := sha256.Sum256(encoded)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Converts the maps decoded from YAML, whose keys aren't strings, so they can be encoded as JSON.
+func canonicalConfig(config interface{}) interface{} {
+	switch value := config.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = canonicalConfig(item)
+		}
+
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[key] = canonicalConfig(item)
+		}
+
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			converted[i] = canonicalConfig(item)
+		}
+
+		return converted
+	default:
+		return value
+	}
+}
+
+// IsOutdated returns whether the Secret was rendered with another configuration than the current one, eg: a registry
+// was removed or the credentials in the environment changed since, so it must be rendered again right away.
+func IsOutdated(secret *corev1.Secret, options Options) bool {
+	return secret.Annotations[ConfigHashAnnotation] != options.ConfigHash
+}
+
+// CreateSecretsIfNeeded on the given namespace if they don't already exist. A failing registry doesn't prevent the
+// Secrets of the other registries from being created.
+func CreateSecretsIfNeeded(ctx context.Context, client client.Client, registries []reg.Registry, namespace string, options Options) error {
+	var errs []error
+
+	secrets := Group(registries, options)
+
+	for _, name := range Names(registries, options) {
+		err := createSecretIfNeeded(ctx, client, secrets[name], types.NamespacedName{Namespace: namespace, Name: name}, options)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return stderrors.Join(errs...)
+}
+
+// Group returns the registries held by each Secret.
+func Group(registries []reg.Registry, options Options) map[string][]reg.Registry {
+	if !options.PerRegistry {
+		return map[string][]reg.Registry{Name: registries}
+	}
+
+	secrets := map[string][]reg.Registry{}
+
+	for _, registry := range registries {
+		name := SecretName(reg.NameOf(registry), options)
+		secrets[name] = append(secrets[name], registry)
+	}
+
+	return secrets
+}
+
+// Names returns the sorted names of the Secrets holding the given registries.
+func Names(registries []reg.Registry, options Options) []string {
+	var names []string
+	for name := range Group(registries, options) {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// SecretName returns the name of the Secret holding the given registry.
+func SecretName(registryName string, options Options) string {
+	if !options.PerRegistry || registryName == "" {
+		return Name
 	}
+
+	return Name + "-" + registryName
+}
+
+// IsManaged returns whether the Secret name follows the naming of ours, in either mode. The users may name their own
+// Secrets alike, so the Secret must be told apart by other means, eg: its labels or GeneratedNames.
+func IsManaged(name string) bool {
+	return name == Name || strings.HasPrefix(name, Name+"-")
+}
+
+// GeneratedNames returns the sorted names of the Secrets we may generate for the given registries, in either mode. The
+// versions of those Secrets are generated as well, see BaseName.
+func GeneratedNames(registries []reg.Registry) []string {
+	generated := map[string]bool{Name: true}
+	for _, name := range Names(registries, Options{PerRegistry: true}) {
+		generated[name] = true
+	}
+
+	names := make([]string, 0, len(generated))
+	for name := range generated {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func createSecretIfNeeded(ctx context.Context, client client.Client, registries []reg.Registry, secretName types.NamespacedName, options Options) error {
+	// Any version of an immutable Secret will do, it is rotated by the reconciliation
+	if options.Immutable {
+		versions, err := listVersions(ctx, client, secretName.Namespace, secretName.Name)
+		if err != nil {
+			return fmt.Errorf("could not list the versions of the Secret [%s]: %w", secretName, err)
+		}
+
+		if len(versions) > 0 {
+			log.Debugf("No need to create the already existing Secret [%s]", secretName)
+
+			return nil
+		}
+
+		secretName.Name = VersionedName(secretName.Name, 1)
+	}
+
 	secret := &corev1.Secret{}
 
 	err := client.Get(ctx, secretName, secret)
@@ -35,7 +226,7 @@ func CreateSecretIfNeeded(ctx context.Context, client client.Client, registries
 	}
 
 	// Secret is not found, we create it now
-	secret, err = createSecretObject(registries, namespace)
+	secret, err = createSecretObject(registries, secretName, nil, options)
 	if err != nil {
 		return fmt.Errorf("failed to create Secret [%s]: %w", secretName, err)
 	}
@@ -58,7 +249,8 @@ func CreateSecretIfNeeded(ctx context.Context, client client.Client, registries
 	return fmt.Errorf("could not create Secret [%s]: %w", secretName, err)
 }
 
-func createSecretObject(registries []reg.Registry, namespace string) (*corev1.Secret, error) {
+// Creates the desired Secret, keeping the foreign entries of the existing Secret when merging.
+func createSecretObject(registries []reg.Registry, secretName types.NamespacedName, existing *corev1.Secret, options Options) (*corev1.Secret, error) {
 	var registryCredentials []*reg.Credentials
 
 	for _, registry := range registries {
@@ -67,32 +259,97 @@ func createSecretObject(registries []reg.Registry, namespace string) (*corev1.Se
 			return nil, fmt.Errorf("failed to login: %w", err)
 		}
 
-		registryCredentials = append(registryCredentials, credentials)
+		registryCredentials = append(registryCredentials, credentials...)
 	}
 
-	dockerConfigBytes, err := json.Marshal(NewDockerConfig(registryCredentials))
+	dockerConfig := NewDockerConfig(registryCredentials)
+
+	ownedAuths, err := json.Marshal(dockerConfig.Keys())
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshall json: %w", err)
 	}
 
+	if options.MergeForeignEntries && existing != nil {
+		mergeForeignEntries(dockerConfig, existing)
+	}
+
+	dockerConfigKey, dockerConfigBytes, err := dockerConfig.Encode(options.secretType())
+	if err != nil {
+		return nil, err
+	}
+
 	secret := &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.Version,
 			Kind:       "Secret",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: namespace,
-			Name:      "registry-secret",
+			Namespace: secretName.Namespace,
+			Name:      secretName.Name,
 			Labels: map[string]string{
 				"app.kubernetes.io/name": "registry-secret-manager",
 				"registry-secret":        "true",
 			},
+			Annotations: map[string]string{
+				OwnedAuthsAnnotation: string(ownedAuths),
+			},
 		},
-		Type: corev1.SecretTypeDockerConfigJson,
+		Type: options.secretType(),
 		StringData: map[string]string{
-			corev1.DockerConfigJsonKey: string(dockerConfigBytes),
+			dockerConfigKey: string(dockerConfigBytes),
 		},
 	}
 
+	if options.Immutable {
+		immutable := true
+		secret.Immutable = &immutable
+	}
+
+	if options.ConfigHash != "" {
+		secret.Annotations[ConfigHashAnnotation] = options.ConfigHash
+	}
+
+	if expiresAt := reg.EarliestExpiry(registryCredentials); !expiresAt.IsZero() {
+		secret.Annotations[ExpiresAtAnnotation] = expiresAt.UTC().Format(time.RFC3339)
+	}
+
 	return secret, nil
 }
+
+// Adds the entries of the existing Secret which weren't generated by us, and aren't generated anymore either. Secrets
+// written before the generated entries were recorded only hold entries generated by us.
+func mergeForeignEntries(dockerConfig *DockerConfig, existing *corev1.Secret) {
+	annotation, ok := existing.Annotations[OwnedAuthsAnnotation]
+	if !ok {
+		return
+	}
+
+	existingDockerConfig, err := DecodeSecret(existing)
+	if err != nil {
+		log.Warnf("Dropping the foreign entries of the Secret [%s/%s]: %v", existing.Namespace, existing.Name, err)
+
+		return
+	}
+
+	var owned []string
+
+	err = json.Unmarshal([]byte(annotation), &owned)
+	if err != nil {
+		log.Warnf("Dropping the foreign entries of the Secret [%s/%s]: %v", existing.Namespace, existing.Name, err)
+
+		return
+	}
+
+	ownedKeys := map[string]bool{}
+	for _, key := range owned {
+		ownedKeys[key] = true
+	}
+
+	for key, authorization := range existingDockerConfig.Authorizations {
+		if _, generated := dockerConfig.Authorizations[key]; generated || ownedKeys[key] {
+			continue
+		}
+
+		dockerConfig.Authorizations[key] = authorization
+	}
+}
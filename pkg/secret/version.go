@@ -0,0 +1,112 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VersionedName returns the name of the given version of a Secret, eg: registry-secret-ecr-3.
+func VersionedName(name string, version int) string {
+	return fmt.Sprintf("%s-%d", name, version)
+}
+
+// BaseName returns the name of the Secret a version belongs to, eg: registry-secret-ecr for registry-secret-ecr-3. The
+// Secrets which aren't versioned are their own base.
+func BaseName(name string) string {
+	if versionOf(name) == 0 {
+		return name
+	}
+
+	return name[:strings.LastIndex(name, "-")]
+}
+
+// CurrentNames returns the names the ServiceAccounts of the namespace must reference for the given Secrets. When
+// immutable, those are the names of their latest version, and the Secrets without any version yet are left out until
+// they are created.
+func CurrentNames(ctx context.Context, reader client.Reader, names []string, namespace string, options Options) ([]string, error) {
+	if !options.Immutable {
+		return names, nil
+	}
+
+	current := make([]string, 0, len(names))
+
+	for _, name := range names {
+		versions, err := listVersions(ctx, reader, namespace, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not list the versions of the Secret [%s/%s]: %w", namespace, name, err)
+		}
+
+		if len(versions) > 0 {
+			current = append(current, versions[len(versions)-1].Name)
+		}
+	}
+
+	return current, nil
+}
+
+// Returns the version of the Secret, 0 when it isn't versioned.
+func versionOf(name string) int {
+	index := strings.LastIndex(name, "-")
+	if index < 0 || !IsManaged(name[:index]) {
+		return 0
+	}
+
+	suffix := name[index+1:]
+
+	version, err := strconv.Atoi(suffix)
+	if err != nil || version < 1 || strconv.Itoa(version) != suffix {
+		return 0
+	}
+
+	return version
+}
+
+// Returns the managed versions of the Secret on the namespace, from the oldest to the latest. The unversioned Secret,
+// eg: from before the Secrets were immutable, is the oldest one.
+func listVersions(ctx context.Context, reader client.Reader, namespace, name string) ([]corev1.Secret, error) {
+	secrets, err := ListManagedSecrets(ctx, reader, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []corev1.Secret
+
+	for _, secret := range secrets {
+		if BaseName(secret.Name) == name {
+			versions = append(versions, secret)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versionOf(versions[i].Name) < versionOf(versions[j].Name)
+	})
+
+	return versions, nil
+}
+
+// Returns whether any ServiceAccount of the namespace of the Secret still references it.
+func isReferenced(ctx context.Context, reader client.Reader, secret *corev1.Secret) (bool, error) {
+	serviceAccounts := &corev1.ServiceAccountList{}
+
+	err := reader.List(ctx, serviceAccounts, client.InNamespace(secret.Namespace))
+	if err != nil {
+		return false, fmt.Errorf("could not list the ServiceAccounts of the namespace [%s]: %w", secret.Namespace, err)
+	}
+
+	for _, serviceAccount := range serviceAccounts.Items {
+		for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
+			if imagePullSecret.Name == secret.Name {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
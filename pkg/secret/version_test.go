@@ -0,0 +1,211 @@
+package secret_test
+
+import (
+	"context"
+	"registry-secret-manager/pkg/registry"
+	"registry-secret-manager/pkg/secret"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestBaseName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"registry-secret":              "registry-secret",
+		"registry-secret-3":            "registry-secret",
+		"registry-secret-ecr":          "registry-secret-ecr",
+		"registry-secret-ecr-12":       "registry-secret-ecr",
+		"registry-secret-ecr-public":   "registry-secret-ecr-public",
+		"registry-secret-ecr-public-2": "registry-secret-ecr-public",
+		"registry-secret-03":           "registry-secret-03",
+		"registry-secret-0":            "registry-secret-0",
+		"not-managed-by-us-3":          "not-managed-by-us-3",
+	}
+
+	for name, expected := range tests {
+		assert.Equal(t, expected, secret.BaseName(name), name)
+	}
+
+	assert.Equal(t, "registry-secret-ecr-4", secret.VersionedName("registry-secret-ecr", 4))
+}
+
+func TestReconcileImmutable(t *testing.T) {
+	t.Parallel()
+
+	options := secret.Options{Immutable: true, GracePeriod: time.Hour}
+
+	stub := &stubRegistry{credentials: newCredentials("first", "https://foo.bar")}
+	fakeClient := fake.NewClientBuilder().Build()
+	reconciler := secret.NewReconciler(fakeClient, []registry.Registry{stub}, options)
+
+	// The first version is created on demand
+	assert.NoError(t, secret.CreateSecretsIfNeeded(context.TODO(), fakeClient, []registry.Registry{stub}, "team", options))
+
+	first := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "registry-secret-1"}, first))
+	assert.True(t, *first.Immutable)
+
+	names, err := secret.CurrentNames(context.TODO(), fakeClient, []string{secret.Name}, "team", options)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry-secret-1"}, names)
+
+	// Another version exists already, so nothing is created
+	assert.NoError(t, secret.CreateSecretsIfNeeded(context.TODO(), fakeClient, []registry.Registry{stub}, "team", options))
+
+	// Unchanged credentials don't need another version
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "registry-secret-1"}}
+	result, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, secret.ReconcileAfter, result.RequeueAfter)
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "registry-secret-2"}, &corev1.Secret{})))
+
+	// New credentials are written into the next version, the first one is collected after the grace period
+	stub.credentials = newCredentials("second", "https://foo.bar")
+	result, err = reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, options.GracePeriod, result.RequeueAfter)
+
+	second := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "registry-secret-2"}, second))
+	assert.True(t, *second.Immutable)
+	assert.Contains(t, second.StringData[corev1.DockerConfigJsonKey], `"password":"second"`)
+
+	names, err = secret.CurrentNames(context.TODO(), fakeClient, []string{secret.Name}, "team", options)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry-secret-2"}, names)
+}
+
+func TestReconcileImmutableCollectsSupersededVersions(t *testing.T) {
+	t.Parallel()
+
+	options := secret.Options{Immutable: true, GracePeriod: time.Hour}
+
+	tests := []struct {
+		name       string
+		createdAgo time.Duration
+		referenced bool
+		collected  bool
+	}{
+		{
+			name:       "within the grace period",
+			createdAgo: time.Minute,
+			collected:  false,
+		},
+		{
+			name:       "still referenced",
+			createdAgo: 2 * options.GracePeriod,
+			referenced: true,
+			collected:  false,
+		},
+		{
+			name:       "unused",
+			createdAgo: 2 * options.GracePeriod,
+			collected:  true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			objects := []client.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry-secret", Labels: managedLabels}},
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+					Namespace:         "team",
+					Name:              "registry-secret-1",
+					Labels:            managedLabels,
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-test.createdAgo)),
+				}},
+			}
+
+			if test.referenced {
+				objects = append(objects, &corev1.ServiceAccount{
+					ObjectMeta:       metav1.ObjectMeta{Namespace: "team", Name: "default"},
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry-secret"}},
+				})
+			}
+
+			fakeClient := fake.NewClientBuilder().WithObjects(objects...).Build()
+			reconciler := secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{}}, options)
+
+			// The unversioned Secret from before the Secrets were immutable is superseded by the first version
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "registry-secret"}}
+			result, err := reconciler.Reconcile(context.TODO(), request)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.collected, errors.IsNotFound(fakeClient.Get(context.TODO(), request.NamespacedName, &corev1.Secret{})))
+
+			if !test.collected {
+				assert.Greater(t, result.RequeueAfter, time.Duration(0))
+			}
+
+			// The current version is never collected
+			assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "registry-secret-1"}, &corev1.Secret{}))
+		})
+	}
+}
+
+func TestReconcileCollectsVersionsOnceMutable(t *testing.T) {
+	t.Parallel()
+
+	options := secret.Options{GracePeriod: time.Hour}
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry-secret-2"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "registry-secret-2"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "other",
+			Name:              "registry-secret",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * options.GracePeriod)),
+		}},
+	).Build()
+	reconciler := secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{}}, options)
+
+	// The version is kept, and never updated, until the unversioned Secret exists
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "registry-secret-2"}}
+	result, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, options.GracePeriod, result.RequeueAfter)
+
+	version := &corev1.Secret{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), request.NamespacedName, version))
+	assert.Empty(t, version.StringData)
+
+	// The version superseded by the unversioned Secret is collected
+	request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "other", Name: "registry-secret-2"}}
+	_, err = reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), request.NamespacedName, &corev1.Secret{})))
+}
+
+func TestReconcileCollectsVersionsWithoutGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry-secret-2"}},
+	).Build()
+	reconciler := secret.NewReconciler(fakeClient, []registry.Registry{&stubRegistry{}}, secret.Options{})
+
+	// Without a grace period the version is still requeued, so it is collected eventually
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team", Name: "registry-secret-2"}}
+	result, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.Equal(t, secret.MinReconcileAfter, result.RequeueAfter)
+}
@@ -1,32 +1,38 @@
 package serviceaccount
 
 import (
+	"context"
 	"fmt"
 	"registry-secret-manager/pkg/registry"
+	"registry-secret-manager/pkg/secret"
 
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 // NewController initializes a service account controller.
-func NewController(mgr manager.Manager, registries []registry.Registry) error {
+func NewController(mgr manager.Manager, registries []registry.Registry, enqueuer secret.Enqueuer, options secret.Options) error {
 	// Setup the webhooks
 	server := mgr.GetWebhookServer()
 	server.Register("/mutate", &webhook.Admission{
-		Handler: NewMutator(mgr.GetClient(), registries),
+		Handler: NewMutator(enqueuer, registries, options),
 	})
 
 	// Setup the reconciler
 	serviceAccountController, err := controller.New("serviceaccount", mgr, controller.Options{
-		Reconciler: NewReconciler(mgr.GetClient(), registries),
+		Reconciler: NewReconciler(mgr.GetClient(), registries, options),
 	})
 	if err != nil {
 		return fmt.Errorf("unable to set up ServiceAccount controller: %w", err)
@@ -63,5 +69,71 @@ func NewController(mgr manager.Manager, registries []registry.Registry) error {
 		return fmt.Errorf("unable to watch ServiceAccounts: %w", err)
 	}
 
+	if !options.Immutable {
+		return nil
+	}
+
+	// Only handle the Secrets that matches these labels
+	labelSelector, err := predicate.LabelSelectorPredicate(metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app.kubernetes.io/name": "registry-secret-manager",
+			"registry-secret":        "true",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create label selector for Secrets: %w", err)
+	}
+
+	// Repoint the ServiceAccounts of the namespace to every new version of the Secrets
+	err = serviceAccountController.Watch(
+		&source.Kind{
+			Type: &corev1.Secret{},
+		},
+		handler.EnqueueRequestsFromMapFunc(EnqueueServiceAccounts(mgr.GetClient())),
+		labelSelector,
+		predicate.Funcs{
+			UpdateFunc: func(event event.UpdateEvent) bool {
+				return false
+			},
+			DeleteFunc: func(event event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(event event.GenericEvent) bool {
+				return false
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to watch Secrets: %w", err)
+	}
+
 	return nil
 }
+
+// EnqueueServiceAccounts returns a function listing the ServiceAccounts of the namespace of a Secret to reconcile.
+func EnqueueServiceAccounts(reader client.Reader) handler.MapFunc {
+	return func(object client.Object) []reconcile.Request {
+		serviceAccounts := &corev1.ServiceAccountList{}
+
+		err := reader.List(context.Background(), serviceAccounts, client.InNamespace(object.GetNamespace()))
+		if err != nil {
+			log.Errorf("Failed to list the ServiceAccounts to repoint to [%s/%s]: %v", object.GetNamespace(), object.GetName(), err)
+
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(serviceAccounts.Items))
+		for _, serviceAccount := range serviceAccounts.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: serviceAccount.Namespace,
+					Name:      serviceAccount.Name,
+				},
+			})
+		}
+
+		log.Debugf("Repointing %d ServiceAccounts to [%s/%s]", len(requests), object.GetNamespace(), object.GetName())
+
+		return requests
+	}
+}
@@ -5,30 +5,40 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"registry-secret-manager/pkg/metrics"
 	"registry-secret-manager/pkg/registry"
 	"registry-secret-manager/pkg/secret"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 type Mutator struct {
-	client     client.Client
-	registries []registry.Registry
+	enqueuer  secret.Enqueuer
+	names     []string
+	generated []string
+	options   secret.Options
 
+	client  client.Client
 	decoder *admission.Decoder
 }
 
-func NewMutator(client client.Client, registries []registry.Registry) *Mutator {
+func NewMutator(enqueuer secret.Enqueuer, registries []registry.Registry, options secret.Options) *Mutator {
 	return &Mutator{
-		client:     client,
-		registries: registries,
+		enqueuer:  enqueuer,
+		names:     secret.Names(registries, options),
+		generated: secret.GeneratedNames(registries),
+		options:   options,
 	}
 }
 
 func (m *Mutator) Handle(ctx context.Context, request admission.Request) admission.Response {
+	defer metrics.ObserveWebhookDuration("serviceaccount", time.Now())
+
 	log.Debugf("Received request to mutate ServiceAccount [%s/%s]", request.Namespace, request.Name)
 
 	// Decode the ServiceAccount from the request
@@ -42,19 +52,39 @@ func (m *Mutator) Handle(ctx context.Context, request admission.Request) admissi
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	// Create the secret if needed
-	err = secret.CreateSecretIfNeeded(ctx, m.client, m.registries, request.Namespace)
+	// Request the creation of the secret, but a dry-run request must not have any side effects.
+	// The registries are never called from here, so the response time doesn't depend on them.
+	if !secret.IsDryRun(request) {
+		m.enqueuer.Enqueue(request.Namespace)
+	}
+
+	// On updates the previous ServiceAccount tells us where our Secret used to be
+	var previous *corev1.ServiceAccount
+
+	if request.Operation == admissionv1.Update {
+		previous = &corev1.ServiceAccount{}
+
+		err = m.decoder.DecodeRaw(request.OldObject, previous)
+		if err != nil {
+			err = fmt.Errorf("failed to decode old ServiceAccount [%s/%s]: %w", request.Namespace, request.Name, err)
+			log.Error(err)
+
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	// Mutate the ServiceAccount if needed, with the current versions of the Secrets. The reconciliation catches up when
+	// they can't be resolved, rather than failing the admission.
+	names, err := secret.CurrentNames(ctx, m.client, m.names, request.Namespace, m.options)
 	if err != nil {
-		// We should not prevent the ServiceAccount from being mutated if the Secret creation fails.
-		// This is safe to do as the Reconciler will attempt to create the Secret anyway.
-		err := fmt.Errorf("failed to create the secret, but ignoring the error: %w", err)
-		log.Error(err)
+		reason := fmt.Sprintf("Could not resolve the Secrets of ServiceAccount [%s/%s]: %v", request.Namespace, request.Name, err)
+		log.Warn(reason)
 
-		return admission.Errored(http.StatusFailedDependency, err)
+		return admission.Allowed(reason)
 	}
 
-	// Mutate the ServiceAccount if needed
-	if !needsMutation(serviceAccount) {
+	names = desiredSecrets(serviceAccount, names, m.options)
+	if !needsMutation(serviceAccount, names, m.generated) {
 		reason := fmt.Sprintf("No mutation needed for ServiceAccount [%s/%s]", request.Namespace, request.Name)
 		log.Debug(reason)
 
@@ -64,9 +94,7 @@ func (m *Mutator) Handle(ctx context.Context, request admission.Request) admissi
 	// Patch the ServiceAccount with the secret
 	log.Infof("Responding with a patch to ServiceAccount [%s/%s]", request.Namespace, request.Name)
 
-	serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{
-		Name: "registry-secret",
-	})
+	addImagePullSecrets(serviceAccount, previous, names, m.generated)
 
 	patched, err := json.Marshal(serviceAccount)
 	if err != nil {
@@ -79,6 +107,12 @@ func (m *Mutator) Handle(ctx context.Context, request admission.Request) admissi
 	return admission.PatchResponseFromRaw(request.Object.Raw, patched)
 }
 
+func (m *Mutator) InjectClient(client client.Client) error {
+	m.client = client
+
+	return nil
+}
+
 func (m *Mutator) InjectDecoder(decoder *admission.Decoder) error {
 	m.decoder = decoder
 
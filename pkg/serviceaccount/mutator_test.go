@@ -3,57 +3,76 @@ package serviceaccount_test
 import (
 	"context"
 	"encoding/json"
+	"registry-secret-manager/pkg/registry"
+	"registry-secret-manager/pkg/secret"
 	"registry-secret-manager/pkg/serviceaccount"
+	"sync"
 	"testing"
 
+	jsonpatchapply "github.com/evanphx/json-patch"
 	"github.com/stretchr/testify/assert"
 	"gomodules.xyz/jsonpatch/v2"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+type fakeEnqueuer struct {
+	mutex      sync.Mutex
+	namespaces []string
+}
+
+func (f *fakeEnqueuer) Enqueue(namespace string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.namespaces = append(f.namespaces, namespace)
+}
+
 func TestHandle(t *testing.T) {
 	t.Parallel()
 
 	jsonPatchType := admissionv1.PatchTypeJSONPatch
 
 	tests := []struct {
-		name             string
-		mustCreateSecret bool
-		target           *corev1.ServiceAccount
-		patchType        *admissionv1.PatchType
-		patch            []jsonpatch.JsonPatchOperation
+		name      string
+		dryRun    bool
+		target    *corev1.ServiceAccount
+		patchType *admissionv1.PatchType
+		patch     []jsonpatch.JsonPatchOperation
 	}{
 		{
-			name:             "no patch needed, must create the secret",
-			mustCreateSecret: true,
-			target:           newServiceAccount(1, "registry-secret"),
+			name:   "no patch needed",
+			target: newServiceAccount(1, "registry-secret"),
 		},
 		{
-			name:             "no secrets at all, must create the secret",
-			mustCreateSecret: true,
-			target:           newServiceAccount(1),
-			patchType:        &jsonPatchType,
+			name:      "no secrets at all",
+			target:    newServiceAccount(1),
+			patchType: &jsonPatchType,
 			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/metadata/annotations",
+				Value:     map[string]interface{}{serviceaccount.InjectedSecretsAnnotation: "registry-secret"},
+			}, {
 				Operation: "add",
 				Path:      "/imagePullSecrets",
 				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
 			}},
 		},
 		{
-			name:             "no secrets managed by us, must create the secret",
-			mustCreateSecret: true,
-			target:           newServiceAccount(1, "not-managed-by-us"),
-			patchType:        &jsonPatchType,
+			name:      "no secrets managed by us",
+			target:    newServiceAccount(1, "not-managed-by-us"),
+			patchType: &jsonPatchType,
 			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/metadata/annotations",
+				Value:     map[string]interface{}{serviceaccount.InjectedSecretsAnnotation: "registry-secret"},
+			}, {
 				Operation: "add",
 				Path:      "/imagePullSecrets/1",
 				Value:     map[string]interface{}{"name": "registry-secret"},
@@ -61,32 +80,25 @@ func TestHandle(t *testing.T) {
 		},
 
 		{
-			name:             "no patch needed, must not create the secret",
-			mustCreateSecret: false,
-			target:           newServiceAccount(1, "registry-secret"),
+			name:   "no patch needed on dry-run",
+			dryRun: true,
+			target: newServiceAccount(1, "registry-secret"),
 		},
 		{
-			name:             "no secrets at all, must not create the secret",
-			mustCreateSecret: false,
-			target:           newServiceAccount(1),
-			patchType:        &jsonPatchType,
+			name:      "no secrets at all on dry-run",
+			dryRun:    true,
+			target:    newServiceAccount(1),
+			patchType: &jsonPatchType,
 			patch: []jsonpatch.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/metadata/annotations",
+				Value:     map[string]interface{}{serviceaccount.InjectedSecretsAnnotation: "registry-secret"},
+			}, {
 				Operation: "add",
 				Path:      "/imagePullSecrets",
 				Value:     []interface{}{map[string]interface{}{"name": "registry-secret"}},
 			}},
 		},
-		{
-			name:             "no secrets managed by us, must not create the secret",
-			mustCreateSecret: false,
-			target:           newServiceAccount(1, "not-managed-by-us"),
-			patchType:        &jsonPatchType,
-			patch: []jsonpatch.JsonPatchOperation{{
-				Operation: "add",
-				Path:      "/imagePullSecrets/1",
-				Value:     map[string]interface{}{"name": "registry-secret"},
-			}},
-		},
 	}
 
 	for _, test := range tests {
@@ -94,40 +106,16 @@ func TestHandle(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			assertMutate(t, test.target, test.patchType, test.patch, test.mustCreateSecret)
+			assertMutate(t, test.target, test.patchType, test.patch, test.dryRun)
 		})
 	}
 }
 
-func assertMutate(t *testing.T, target *corev1.ServiceAccount, patchType *admissionv1.PatchType, patch []jsonpatch.JsonPatchOperation, mustCreateTheSecret bool) {
+func assertMutate(t *testing.T, target *corev1.ServiceAccount, patchType *admissionv1.PatchType, patch []jsonpatch.JsonPatchOperation, dryRun bool) {
 	t.Helper()
 
-	secretName := types.NamespacedName{
-		Namespace: "registry-secret-manager",
-		Name:      "registry-secret",
-	}
-
-	var objects []client.Object
-	if !mustCreateTheSecret {
-		// Secret is not created by the mutator
-		objects = append(objects, &corev1.Secret{
-			TypeMeta: metav1.TypeMeta{
-				APIVersion: corev1.SchemeGroupVersion.Version,
-				Kind:       "Secret",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace:       secretName.Namespace,
-				Name:            secretName.Name,
-				ResourceVersion: "1",
-			},
-		})
-	}
-
-	fakeClientBuilder := fake.NewClientBuilder()
-	fakeClientBuilder.WithObjects(objects...)
-
-	fakeClient := fakeClientBuilder.Build()
-	mutator := serviceaccount.NewMutator(fakeClient, nil)
+	enqueuer := &fakeEnqueuer{}
+	mutator := serviceaccount.NewMutator(enqueuer, nil, secret.Options{})
 
 	decoder, _ := admission.NewDecoder(scheme.Scheme)
 	_ = mutator.InjectDecoder(decoder)
@@ -142,18 +130,271 @@ func assertMutate(t *testing.T, target *corev1.ServiceAccount, patchType *admiss
 			Namespace: "registry-secret-manager",
 			Name:      "default",
 			Object:    runtime.RawExtension{Raw: serviceAccountJSON},
+			DryRun:    &dryRun,
 		},
 	}
 	response := mutator.Handle(context.TODO(), request)
 
 	assert.True(t, response.Allowed)
 	assert.Equal(t, patchType, response.PatchType)
-	assert.Equal(t, patch, response.Patches)
+	assert.ElementsMatch(t, patch, response.Patches)
 
-	// Check if the secret was created or updated, either way ResourceVersion should always be 1
-	var desiredSecret corev1.Secret
-	err = fakeClient.Get(context.TODO(), secretName, &desiredSecret)
+	// The creation of the secret is always requested, unless it's a dry-run
+	if dryRun {
+		assert.Empty(t, enqueuer.namespaces)
+	} else {
+		assert.Equal(t, []string{"registry-secret-manager"}, enqueuer.namespaces)
+	}
+}
 
-	assert.NoError(t, err)
-	assert.Equal(t, "1", desiredSecret.ResourceVersion)
+func TestHandleUpdate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		previous *corev1.ServiceAccount
+		target   *corev1.ServiceAccount
+		expected *corev1.ServiceAccount
+	}{
+		{
+			name:     "reference kept",
+			previous: newServiceAccount(1, "registry-secret"),
+			target:   newServiceAccount(1, "registry-secret", "not-managed-by-us"),
+			expected: newServiceAccount(1, "registry-secret", "not-managed-by-us"),
+		},
+		{
+			name:     "reference removed, restored on its former position",
+			previous: newServiceAccount(1, "first", "registry-secret", "second"),
+			target:   newServiceAccount(1, "first", "second"),
+			expected: newServiceAccount(1, "first", "registry-secret", "second"),
+		},
+		{
+			name:     "all references removed",
+			previous: newServiceAccount(1, "first", "registry-secret"),
+			target:   newServiceAccount(1),
+			expected: newServiceAccount(1, "registry-secret"),
+		},
+		{
+			name:     "reference never present, appended",
+			previous: newServiceAccount(1, "first"),
+			target:   newServiceAccount(1, "second", "first"),
+			expected: newServiceAccount(1, "second", "first", "registry-secret"),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			mutator := serviceaccount.NewMutator(&fakeEnqueuer{}, nil, secret.Options{})
+
+			decoder, _ := admission.NewDecoder(scheme.Scheme)
+			_ = mutator.InjectDecoder(decoder)
+
+			previousJSON, err := json.Marshal(test.previous)
+			assert.NoError(t, err)
+
+			targetJSON, err := json.Marshal(test.target)
+			assert.NoError(t, err)
+
+			request := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "ServiceAccount"},
+					Namespace: "registry-secret-manager",
+					Name:      "default",
+					Operation: admissionv1.Update,
+					Object:    runtime.RawExtension{Raw: targetJSON},
+					OldObject: runtime.RawExtension{Raw: previousJSON},
+				},
+			}
+			response := mutator.Handle(context.TODO(), request)
+
+			assert.True(t, response.Allowed)
+
+			// Apply the patch and verify the resulting order of the Secrets
+			patchJSON, err := json.Marshal(response.Patches)
+			assert.NoError(t, err)
+
+			patch, err := jsonpatchapply.DecodePatch(patchJSON)
+			assert.NoError(t, err)
+
+			patchedJSON, err := patch.Apply(targetJSON)
+			assert.NoError(t, err)
+
+			patched := &corev1.ServiceAccount{}
+			assert.NoError(t, json.Unmarshal(patchedJSON, patched))
+			assert.Equal(t, test.expected.ImagePullSecrets, patched.ImagePullSecrets)
+		})
+	}
+}
+
+func TestHandlePerRegistry(t *testing.T) {
+	t.Parallel()
+
+	// The registries are never logged into by the mutator, only their names matter
+	registries := []registry.Registry{
+		registry.NewNamed(nil, "docker-hub"),
+		registry.NewNamed(nil, "ecr"),
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		target      *corev1.ServiceAccount
+		expected    *corev1.ServiceAccount
+		injected    string
+	}{
+		{
+			name:     "single Secret replaced",
+			target:   newServiceAccount(1, "first", "registry-secret"),
+			expected: newServiceAccount(1, "first", "registry-secret-docker-hub", "registry-secret-ecr"),
+			injected: "registry-secret-docker-hub,registry-secret-ecr",
+		},
+		{
+			name:        "disabled registry removed",
+			annotations: map[string]string{serviceaccount.InjectedSecretsAnnotation: "registry-secret-docker-hub,registry-secret-gitlab"},
+			target:      newServiceAccount(1, "registry-secret-docker-hub", "registry-secret-gitlab"),
+			expected:    newServiceAccount(1, "registry-secret-docker-hub", "registry-secret-ecr"),
+			injected:    "registry-secret-docker-hub,registry-secret-ecr",
+		},
+		{
+			name:     "Secret of the user named alike ours kept",
+			target:   newServiceAccount(1, "registry-secret-legacy", "registry-secret-gitlab-2"),
+			expected: newServiceAccount(1, "registry-secret-legacy", "registry-secret-gitlab-2", "registry-secret-docker-hub", "registry-secret-ecr"),
+			injected: "registry-secret-docker-hub,registry-secret-ecr",
+		},
+		{
+			name:        "registries restricted by annotation",
+			annotations: map[string]string{serviceaccount.RegistriesAnnotation: "ecr, unknown"},
+			target:      newServiceAccount(1, "registry-secret-docker-hub", "not-managed-by-us"),
+			expected:    newServiceAccount(1, "not-managed-by-us", "registry-secret-ecr"),
+			injected:    "registry-secret-ecr",
+		},
+	}
+
+	for _, test := range tests {
+		mutator := serviceaccount.NewMutator(&fakeEnqueuer{}, registries, secret.Options{PerRegistry: true})
+
+		decoder, _ := admission.NewDecoder(scheme.Scheme)
+		_ = mutator.InjectDecoder(decoder)
+
+		test.target.Annotations = test.annotations
+
+		targetJSON, err := json.Marshal(test.target)
+		assert.NoError(t, err)
+
+		request := admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "ServiceAccount"},
+				Namespace: "registry-secret-manager",
+				Name:      "default",
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: targetJSON},
+			},
+		}
+		response := mutator.Handle(context.TODO(), request)
+
+		assert.True(t, response.Allowed, test.name)
+
+		patchJSON, err := json.Marshal(response.Patches)
+		assert.NoError(t, err)
+
+		patch, err := jsonpatchapply.DecodePatch(patchJSON)
+		assert.NoError(t, err)
+
+		patchedJSON, err := patch.Apply(targetJSON)
+		assert.NoError(t, err)
+
+		patched := &corev1.ServiceAccount{}
+		assert.NoError(t, json.Unmarshal(patchedJSON, patched))
+		assert.Equal(t, test.expected.ImagePullSecrets, patched.ImagePullSecrets, test.name)
+		assert.Equal(t, test.injected, patched.Annotations[serviceaccount.InjectedSecretsAnnotation], test.name)
+	}
+}
+
+func TestHandleImmutable(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		newVersion("registry-secret-1"),
+		newVersion("registry-secret-2"),
+	).Build()
+
+	tests := []struct {
+		name     string
+		target   *corev1.ServiceAccount
+		expected *corev1.ServiceAccount
+		injected string
+	}{
+		{
+			name:     "no secrets at all",
+			target:   newServiceAccount(1, "first"),
+			expected: newServiceAccount(1, "first", "registry-secret-2"),
+			injected: "registry-secret-2",
+		},
+		{
+			name:     "previous version repointed in place",
+			target:   newServiceAccount(1, "first", "registry-secret-1", "second"),
+			expected: newServiceAccount(1, "first", "registry-secret-2", "second"),
+			injected: "registry-secret-2",
+		},
+		{
+			name:     "unversioned Secret repointed in place",
+			target:   newServiceAccount(1, "registry-secret", "first"),
+			expected: newServiceAccount(1, "registry-secret-2", "first"),
+			injected: "registry-secret-2",
+		},
+	}
+
+	for _, test := range tests {
+		mutator := serviceaccount.NewMutator(&fakeEnqueuer{}, nil, secret.Options{Immutable: true})
+
+		decoder, _ := admission.NewDecoder(scheme.Scheme)
+		_ = mutator.InjectDecoder(decoder)
+		_ = mutator.InjectClient(fakeClient)
+
+		targetJSON, err := json.Marshal(test.target)
+		assert.NoError(t, err)
+
+		request := admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "ServiceAccount"},
+				Namespace: "registry-secret-manager",
+				Name:      "default",
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: targetJSON},
+			},
+		}
+		response := mutator.Handle(context.TODO(), request)
+
+		assert.True(t, response.Allowed, test.name)
+
+		patchJSON, err := json.Marshal(response.Patches)
+		assert.NoError(t, err)
+
+		patch, err := jsonpatchapply.DecodePatch(patchJSON)
+		assert.NoError(t, err)
+
+		patchedJSON, err := patch.Apply(targetJSON)
+		assert.NoError(t, err)
+
+		patched := &corev1.ServiceAccount{}
+		assert.NoError(t, json.Unmarshal(patchedJSON, patched))
+		assert.Equal(t, test.expected.ImagePullSecrets, patched.ImagePullSecrets, test.name)
+		assert.Equal(t, test.injected, patched.Annotations[serviceaccount.InjectedSecretsAnnotation], test.name)
+	}
+}
+
+func newVersion(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "registry-secret-manager",
+			Name:      name,
+			Labels: map[string]string{
+				"app.kubernetes.io/name": "registry-secret-manager",
+				"registry-secret":        "true",
+			},
+		},
+	}
 }
@@ -17,12 +17,14 @@ import (
 type Reconciler struct {
 	client     client.Client
 	registries []registry.Registry
+	options    secret.Options
 }
 
-func NewReconciler(client client.Client, registries []registry.Registry) *Reconciler {
+func NewReconciler(client client.Client, registries []registry.Registry, options secret.Options) *Reconciler {
 	return &Reconciler{
 		client:     client,
 		registries: registries,
+		options:    options,
 	}
 }
 
@@ -48,7 +50,7 @@ func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 	}
 
 	// Create the secret if needed
-	err = secret.CreateSecretIfNeeded(ctx, r.client, r.registries, request.Namespace)
+	err = secret.CreateSecretsIfNeeded(ctx, r.client, r.registries, request.Namespace, r.options)
 	if err != nil {
 		err = fmt.Errorf("%w", err)
 		log.Error(err)
@@ -56,16 +58,24 @@ func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 		return result, err
 	}
 
-	// Mutate the ServiceAccount if needed
-	if !needsMutation(serviceAccount) {
+	// Mutate the ServiceAccount if needed, with the current versions of the Secrets
+	names, err := secret.CurrentNames(ctx, r.client, secret.Names(r.registries, r.options), request.Namespace, r.options)
+	if err != nil {
+		log.Error(err)
+
+		return result, err
+	}
+
+	generated := secret.GeneratedNames(r.registries)
+
+	names = desiredSecrets(serviceAccount, names, r.options)
+	if !needsMutation(serviceAccount, names, generated) {
 		log.Debugf("No reconcile needed for ServiceAccount [%s]", request.NamespacedName)
 
 		return result, nil
 	}
 
-	serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{
-		Name: "registry-secret",
-	})
+	addImagePullSecrets(serviceAccount, nil, names, generated)
 
 	err = r.client.Update(ctx, serviceAccount)
 	if err != nil {
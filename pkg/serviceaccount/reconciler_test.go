@@ -2,6 +2,7 @@ package serviceaccount_test
 
 import (
 	"context"
+	"registry-secret-manager/pkg/secret"
 	"registry-secret-manager/pkg/serviceaccount"
 	"strconv"
 	"testing"
@@ -35,13 +36,13 @@ func TestReconcile(t *testing.T) {
 			name:             "no secrets at all, must create the secret",
 			mustCreateSecret: true,
 			existing:         newServiceAccount(1),
-			expected:         newServiceAccount(2, "registry-secret"),
+			expected:         withInjectedSecrets(newServiceAccount(2, "registry-secret"), "registry-secret"),
 		},
 		{
 			name:             "no secrets managed by us, must create the secret",
 			mustCreateSecret: true,
 			existing:         newServiceAccount(1, "not-managed-by-us"),
-			expected:         newServiceAccount(2, "not-managed-by-us", "registry-secret"),
+			expected:         withInjectedSecrets(newServiceAccount(2, "not-managed-by-us", "registry-secret"), "registry-secret"),
 		},
 
 		{
@@ -54,13 +55,19 @@ func TestReconcile(t *testing.T) {
 			name:             "no secrets at all, must not create the secret",
 			mustCreateSecret: false,
 			existing:         newServiceAccount(1),
-			expected:         newServiceAccount(2, "registry-secret"),
+			expected:         withInjectedSecrets(newServiceAccount(2, "registry-secret"), "registry-secret"),
 		},
 		{
 			name:             "no secrets managed by us, must not create the secret",
 			mustCreateSecret: false,
 			existing:         newServiceAccount(1, "not-managed-by-us"),
-			expected:         newServiceAccount(2, "not-managed-by-us", "registry-secret"),
+			expected:         withInjectedSecrets(newServiceAccount(2, "not-managed-by-us", "registry-secret"), "registry-secret"),
+		},
+		{
+			name:             "Secret of the user named alike ours kept, must not create the secret",
+			mustCreateSecret: false,
+			existing:         newServiceAccount(1, "registry-secret-legacy"),
+			expected:         withInjectedSecrets(newServiceAccount(2, "registry-secret-legacy", "registry-secret"), "registry-secret"),
 		},
 	}
 
@@ -105,7 +112,7 @@ func assertReconcile(t *testing.T, existing, expected *corev1.ServiceAccount, mu
 	fakeClientBuilder.WithObjects(objects...)
 
 	fakeClient := fakeClientBuilder.Build()
-	reconciler := serviceaccount.NewReconciler(fakeClient, nil)
+	reconciler := serviceaccount.NewReconciler(fakeClient, nil, secret.Options{})
 
 	// Reconcile and verify its content
 	request := reconcile.Request{
@@ -156,3 +163,39 @@ func newServiceAccount(resourceVersion int, imagePullSecrets ...string) *corev1.
 		ImagePullSecrets: secrets,
 	}
 }
+
+func withInjectedSecrets(serviceAccount *corev1.ServiceAccount, injectedSecrets string) *corev1.ServiceAccount {
+	serviceAccount.Annotations = map[string]string{
+		serviceaccount.InjectedSecretsAnnotation: injectedSecrets,
+	}
+
+	return serviceAccount
+}
+
+func TestReconcileImmutable(t *testing.T) {
+	t.Parallel()
+
+	existing := withInjectedSecrets(newServiceAccount(1, "first", "registry-secret-1"), "registry-secret-1")
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		existing,
+		newVersion("registry-secret-1"),
+		newVersion("registry-secret-2"),
+	).Build()
+	reconciler := serviceaccount.NewReconciler(fakeClient, nil, secret.Options{Immutable: true})
+
+	// The ServiceAccount is repointed to the latest version, without creating another one
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: existing.Namespace, Name: existing.Name}}
+	_, err := reconciler.Reconcile(context.TODO(), request)
+
+	assert.NoError(t, err)
+
+	updated := &corev1.ServiceAccount{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), request.NamespacedName, updated))
+	assert.Equal(t, newServiceAccount(2, "first", "registry-secret-2").ImagePullSecrets, updated.ImagePullSecrets)
+	assert.Equal(t, "registry-secret-2", updated.Annotations[serviceaccount.InjectedSecretsAnnotation])
+
+	secrets := &corev1.SecretList{}
+	assert.NoError(t, fakeClient.List(context.TODO(), secrets))
+	assert.Len(t, secrets.Items, 2)
+}
@@ -1,16 +1,180 @@
 package serviceaccount
 
 import (
+	"registry-secret-manager/pkg/secret"
+	"strings"
+
 	corev1 "k8s.io/api/core/v1"
 )
 
-// Check if the ServiceAccount needs mutation.
-func needsMutation(serviceAccount *corev1.ServiceAccount) bool {
+// RegistriesAnnotation restricts the registries whose Secrets are attached to the ServiceAccount, eg: "ecr,ghcr". It
+// only applies when there is one Secret per registry.
+const RegistriesAnnotation = "registry-secret-manager/registries"
+
+// InjectedSecretsAnnotation records the Secrets we added to the ServiceAccount, eg: "registry-secret". The references
+// added by the user themselves are never recorded, and are left untouched on cleanup.
+const InjectedSecretsAnnotation = "registry-secret-manager/injected-secrets"
+
+// InjectedSecrets returns the Secrets we added to the ServiceAccount, and whether they were recorded at all. The
+// ServiceAccounts mutated by older versions don't have them recorded.
+func InjectedSecrets(serviceAccount *corev1.ServiceAccount) ([]string, bool) {
+	annotation, ok := serviceAccount.Annotations[InjectedSecretsAnnotation]
+	if !ok {
+		return nil, false
+	}
+
+	var names []string
+
+	for _, name := range strings.Split(annotation, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, true
+}
+
+// Returns the Secrets to attach to the ServiceAccount among the given ones.
+func desiredSecrets(serviceAccount *corev1.ServiceAccount, names []string, options secret.Options) []string {
+	annotation, ok := serviceAccount.Annotations[RegistriesAnnotation]
+	if !ok || !options.PerRegistry {
+		return names
+	}
+
+	var desired []string
+
+	for _, registryName := range strings.Split(annotation, ",") {
+		for _, name := range names {
+			if secret.BaseName(name) == secret.SecretName(strings.TrimSpace(registryName), options) && !contains(desired, name) {
+				desired = append(desired, name)
+			}
+		}
+	}
+
+	return desired
+}
+
+// Check if the ServiceAccount needs mutation: one of the Secrets is missing, or one of ours isn't desired anymore.
+func needsMutation(serviceAccount *corev1.ServiceAccount, names, generated []string) bool {
+	injected, _ := InjectedSecrets(serviceAccount)
+
+	var present []string
+
+	for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
+		if isOurs(imagePullSecret.Name, generated, injected) && !contains(names, imagePullSecret.Name) {
+			return true
+		}
+
+		present = append(present, imagePullSecret.Name)
+	}
+
+	for _, name := range names {
+		if !contains(present, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add the Secrets to the ServiceAccount, and remove ours which aren't desired anymore. When a previous version of the
+// ServiceAccount is given, the Secrets are restored on their former position, so the order of the user-specified
+// Secrets is preserved. The references to another version of a Secret are repointed in place, so the ServiceAccount
+// never goes without one. The Secrets we added are recorded in the InjectedSecretsAnnotation.
+func addImagePullSecrets(serviceAccount, previous *corev1.ServiceAccount, names, generated []string) {
+	injected, _ := InjectedSecrets(serviceAccount)
+
+	var recorded []string
+
+	for _, name := range names {
+		if contains(injected, name) || !containsReference(serviceAccount.ImagePullSecrets, name) {
+			recorded = append(recorded, name)
+		}
+	}
+
+	setInjectedSecrets(serviceAccount, recorded)
+
+	imagePullSecrets := make([]corev1.LocalObjectReference, 0, len(serviceAccount.ImagePullSecrets)+len(names))
+
 	for _, imagePullSecret := range serviceAccount.ImagePullSecrets {
-		if imagePullSecret.Name == "registry-secret" {
-			return false
+		if !isOurs(imagePullSecret.Name, generated, injected) || contains(names, imagePullSecret.Name) {
+			imagePullSecrets = append(imagePullSecrets, imagePullSecret)
+
+			continue
+		}
+
+		name, ok := currentVersion(names, imagePullSecret.Name)
+		if ok && !containsReference(imagePullSecrets, name) && !containsReference(serviceAccount.ImagePullSecrets, name) {
+			imagePullSecrets = append(imagePullSecrets, corev1.LocalObjectReference{Name: name})
+		}
+	}
+
+	for _, name := range names {
+		if containsReference(imagePullSecrets, name) {
+			continue
+		}
+
+		index := len(imagePullSecrets)
+
+		if previous != nil {
+			for i, imagePullSecret := range previous.ImagePullSecrets {
+				if imagePullSecret.Name == name && i < index {
+					index = i
+
+					break
+				}
+			}
+		}
+
+		imagePullSecrets = append(imagePullSecrets[:index], append([]corev1.LocalObjectReference{{Name: name}}, imagePullSecrets[index:]...)...)
+	}
+
+	serviceAccount.ImagePullSecrets = imagePullSecrets
+}
+
+// Record the Secrets we added to the ServiceAccount. The annotation is kept even when empty, as it tells the
+// references the user added themselves apart from the ones added by older versions.
+func setInjectedSecrets(serviceAccount *corev1.ServiceAccount, names []string) {
+	if serviceAccount.Annotations == nil {
+		serviceAccount.Annotations = map[string]string{}
+	}
+
+	serviceAccount.Annotations[InjectedSecretsAnnotation] = strings.Join(names, ",")
+}
+
+// Returns whether the reference is one of ours: to a Secret we generate, to one of its versions, or recorded as added by
+// us. The references to Secrets the users named alike ours are left untouched.
+func isOurs(name string, generated, injected []string) bool {
+	return contains(generated, secret.BaseName(name)) || contains(injected, name)
+}
+
+// Returns the current version among the given Secrets of the Secret the name is another version of.
+func currentVersion(names []string, name string) (string, bool) {
+	for _, n := range names {
+		if secret.BaseName(n) == secret.BaseName(name) {
+			return n, true
+		}
+	}
+
+	return "", false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsReference(references []corev1.LocalObjectReference, name string) bool {
+	for _, reference := range references {
+		if reference.Name == name {
+			return true
 		}
 	}
 
-	return true
+	return false
 }